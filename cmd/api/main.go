@@ -8,5 +8,5 @@ import (
 )
 
 func main() {
-	internal.Main(context.Background(), "api", routes.Redirections)
+	internal.Main(context.Background(), "api", routes.Redirections, routes.Rules, routes.Namespaces, routes.Domains, routes.APIKeys, routes.Audit)
 }
@@ -0,0 +1,113 @@
+// Command apikeys issues, lists and revokes the API keys of the api, using the
+// same config (environment) as the api:
+//
+//	apikeys issue <name> [viewer|editor|admin] [namespace,...]
+//	apikeys list
+//	apikeys revoke <id>
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/auth"
+)
+
+const usage = "usage: apikeys issue <name> [role] [namespaces] | list | revoke <id>"
+
+func main() {
+	if err := run(context.Background(), os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	config, err := internal.ConfigFromEnv(ctx)
+	if err != nil {
+		cancel()
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	deps, err := internal.Setup(ctx, config)
+	if err != nil {
+		cancel()
+		return fmt.Errorf("failed to setup dependencies: %w", err)
+	}
+	defer func() {
+		cancel()
+		<-deps.Hits.Done()
+	}()
+	keys := deps.APIKeys
+
+	switch {
+	case args[0] == "issue" && len(args) >= 2 && len(args) <= 4:
+		// Keys issued with the command are admin by default, as the first key
+		// is used to issue the others.
+		role := auth.RoleAdmin
+		if len(args) >= 3 {
+			var ok bool
+			if role, ok = auth.ParseRole(args[2]); !ok {
+				return fmt.Errorf("invalid role %q, must be viewer, editor or admin", args[2])
+			}
+		}
+		var namespaces []string
+		if len(args) == 4 {
+			namespaces = strings.Split(args[3], ",")
+		}
+		key, apiKey, err := keys.Issue(ctx, args[1], role, namespaces, time.Now())
+		if err != nil {
+			return fmt.Errorf("failed to issue api key: %w", err)
+		}
+		fmt.Fprintf(os.Stderr, "issued %s api key %d (%s), it can't be shown again:\n", apiKey.Role, apiKey.ID, apiKey.Name)
+		fmt.Println(key)
+
+	case args[0] == "list" && len(args) == 1:
+		list, err := keys.List(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list api keys: %w", err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tPREFIX\tROLE\tNAMESPACES\tCREATED\tREVOKED")
+		for _, key := range list {
+			namespaces, revoked := "-", "-"
+			if len(key.Namespaces) > 0 {
+				namespaces = strings.Join(key.Namespaces, ",")
+			}
+			if key.RevokedAt != nil {
+				revoked = key.RevokedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", key.ID, key.Name, key.Prefix, key.Role, namespaces, key.CreatedAt.Format(time.RFC3339), revoked)
+		}
+		return w.Flush()
+
+	case args[0] == "revoke" && len(args) == 2:
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid id %q", args[1])
+		}
+		revoked, err := keys.Revoke(ctx, id, time.Now())
+		if err != nil {
+			return fmt.Errorf("failed to revoke api key: %w", err)
+		}
+		if !revoked {
+			return fmt.Errorf("api key %d not found", id)
+		}
+		fmt.Fprintf(os.Stderr, "revoked api key %d\n", id)
+
+	default:
+		return errors.New(usage)
+	}
+	return nil
+}
@@ -4,3 +4,12 @@ Feature: Basic API
     When the client does a GET request to "/health"
     Then the response code should be 200 (OK)
     And the response body should be empty
+
+  Scenario: Unknown routes respond with a problem
+    When the client does a GET request to "/does/not/exist"
+    Then the response should be a problem with status 404 (Not Found)
+
+  Scenario: Known routes respond to other methods with the allowed methods
+    When the client does a PATCH request to "/api-keys"
+    Then the response should be a problem with status 405 (Method Not Allowed)
+    And the response header "Allow" should be "GET, HEAD, POST"
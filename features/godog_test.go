@@ -3,20 +3,30 @@ package features_test
 import (
 	"context"
 	"flag"
+	"io"
+	"log/slog"
 	"net/http"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/cucumber/godog"
 	"github.com/koenbollen/go-tested-api-with-sqlite/features/steps"
 	"github.com/koenbollen/go-tested-api-with-sqlite/internal"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/auth"
 	"github.com/koenbollen/go-tested-api-with-sqlite/internal/routes"
 	"github.com/koenbollen/go-tested-api-with-sqlite/internal/util/timeutil"
+	"github.com/koenbollen/logging"
 )
 
 var AllRoutes = []internal.Route{
 	routes.Redirections,
+	routes.Rules,
+	routes.Namespaces,
+	routes.Domains,
+	routes.APIKeys,
+	routes.Audit,
 }
 
 type stepCollection interface {
@@ -34,6 +44,7 @@ func TestMain(m *testing.M) {
 		Paths:     []string{"."},
 		Tags:      "~@wip && ~@todo",
 		Randomize: -1,
+		Strict:    true,
 	}
 	godog.BindCommandLineFlags("godog.", &opts)
 	flag.Parse()
@@ -43,12 +54,18 @@ func TestMain(m *testing.M) {
 
 	httpSteps := &steps.HTTPSteps{}
 	databaseSteps := &steps.DatabaseSteps{}
+	jwtSteps := &steps.JWTSteps{HTTP: httpSteps}
 	stepCollections := []stepCollection{
 		httpSteps,
 		databaseSteps,
+		&steps.TimeSteps{},
+		&steps.RandomSteps{},
+		jwtSteps,
 	}
 
 	var mux *http.ServeMux
+	var deps *internal.Dependencies
+	var stop context.CancelFunc
 
 	suite := godog.TestSuite{
 		Name:    "go-tested-api-with-sqlite",
@@ -59,8 +76,9 @@ func TestMain(m *testing.M) {
 					panic(err)
 				}
 			}
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
 			httpSteps.ApplicationMux = func() http.Handler {
-				return mux
+				return logging.Middleware(mux, logger)
 			}
 		},
 		ScenarioInitializer: func(scenario *godog.ScenarioContext) {
@@ -69,19 +87,90 @@ func TestMain(m *testing.M) {
 					panic(err)
 				}
 			}
-			scenario.Before(func(ctx context.Context, sc *godog.Scenario) (context.Context, error) {
-				t, _ := time.Parse(time.RFC3339, "2009-11-10T23:00:00Z")
-				ctx = timeutil.WithTime(ctx, t)
+			// setup (re)starts the application with the given config, the
+			// dependencies of a previous setup are stopped first.
+			setup := func(ctx context.Context, config *internal.Config) error {
+				if stop != nil {
+					stop()
+					<-deps.Hits.Done()
+				}
 
-				deps, err := internal.Setup(ctx, internal.DefaultConfig())
+				// Background work of the dependencies is stopped after each
+				// scenario.
+				var setupCtx context.Context
+				setupCtx, stop = context.WithCancel(ctx)
+
+				var err error
+				deps, err = internal.Setup(setupCtx, config)
 				if err != nil {
-					panic(err)
+					return err
 				}
-				mux, err = internal.SetupRoutes(ctx, deps, AllRoutes...)
+				mux, err = internal.SetupRoutes(setupCtx, deps, AllRoutes...)
 				if err != nil {
-					panic(err)
+					return err
 				}
 				databaseSteps.DB = deps.DB
+
+				// Scenarios are authenticated with an admin test key by
+				// default, the header can be changed or removed by the
+				// scenario.
+				key, _, err := deps.APIKeys.Issue(ctx, "test", auth.RoleAdmin, nil, timeutil.Now(ctx))
+				if err != nil {
+					return err
+				}
+				httpSteps.ExtraHeaders.Set("Authorization", "Bearer "+key)
+				return nil
+			}
+
+			// Changing the config restarts the application with an empty
+			// database, so these steps should come before any records are
+			// created.
+			scenario.Step(`^the config "([^"]*)" is "([^"]*)"$`, func(ctx context.Context, name, value string) error {
+				config := *deps.Config
+				if err := config.Set(name, value); err != nil {
+					return err
+				}
+				return setup(ctx, &config)
+			})
+			scenario.Step(`^the client authenticates as "([^"]*)" with role "([^"]*)"(?: in namespaces "([^"]*)")?$`, func(ctx context.Context, name, role, namespaces string) error {
+				var allowed []string
+				if namespaces != "" {
+					allowed = strings.Split(namespaces, ",")
+				}
+				key, _, err := deps.APIKeys.Issue(ctx, name, auth.Role(role), allowed, timeutil.Now(ctx))
+				if err != nil {
+					return err
+				}
+				httpSteps.ExtraHeaders.Set("Authorization", "Bearer "+key)
+				return nil
+			})
+			scenario.Step(`^all pending hits are flushed$`, func(ctx context.Context) error {
+				return deps.Hits.Flush(ctx)
+			})
+			scenario.Step(`^the sweeper has run$`, func(ctx context.Context) error {
+				return internal.Sweep(ctx, deps)
+			})
+			// Closing the database makes every following query fail, e.g. to
+			// show a response doesn't need it.
+			scenario.Step(`^the database is unavailable$`, func(ctx context.Context) error {
+				return deps.DB.Close()
+			})
+			scenario.Before(func(ctx context.Context, sc *godog.Scenario) (context.Context, error) {
+				t, _ := time.Parse(time.RFC3339, "2009-11-10T23:00:00Z")
+				ctx = timeutil.WithTime(ctx, t)
+
+				// JWTs signed by the stub issuer of the JWT steps are accepted.
+				config := internal.DefaultConfig()
+				config.JWKSURL = jwtSteps.JWKSURL
+				if err := setup(ctx, config); err != nil {
+					panic(err)
+				}
+				return ctx, nil
+			})
+			scenario.After(func(ctx context.Context, sc *godog.Scenario, err error) (context.Context, error) {
+				stop()
+				<-deps.Hits.Done()
+				stop = nil
 				return ctx, nil
 			})
 		},
@@ -0,0 +1,80 @@
+Feature: Audit log
+
+  Every change of a redirection is appended to the audit log, with who made
+  the change and the redirection before and after it. Admins can read the log
+  at /audit, newest first.
+
+  Scenario: Record the creation, update and deletion of a redirection
+    When the client does a POST request to "/redirections" with the following data:
+      """json
+      {"key": "test", "url": "http://example.com"}
+      """
+    And the client does a PATCH request to "/redirections/test" with the following data:
+      """json
+      {"url": "http://example.org"}
+      """
+    And the client does a DELETE request to "/redirections/test"
+    Then this "audit" record exists:
+      | id         | 1                    |
+      | namespace  | default              |
+      | key        | test                 |
+      | action     | create               |
+      | actor      | api_key:1            |
+      | created_at | 2009-11-10T23:00:00Z |
+    When the client does a GET request to "/audit"
+    Then the response code should be 200 (OK)
+    And the response JSON field "items.0.action" should be "delete"
+    And the response JSON field "items.0.before.url" should be "http://example.org"
+    And the response JSON field "items.0.after" should be "<nil>"
+    And the response JSON field "items.1.action" should be "update"
+    And the response JSON field "items.1.before.url" should be "http://example.com"
+    And the response JSON field "items.1.after.url" should be "http://example.org"
+    And the response JSON field "items.2.action" should be "create"
+    And the response JSON field "items.2.before" should be "<nil>"
+    And the response JSON field "items.2.actor" should be "api_key:1"
+    And the response JSON field "items.2.request_id" should match "^r[0-9a-z]+$"
+
+  Scenario: Don't record failed changes
+    Given the client authenticates as "alice" with role "editor"
+    And these "redirection" records exist:
+      | key    | url                 | owner     |
+      | theirs | https://example.com | api_key:3 |
+    When the client does a DELETE request to "/redirections/theirs"
+    Then the response code should be 403 (Forbidden)
+    When the client does a POST request to "/redirections" with the following data:
+      """json
+      {"key": "theirs", "url": "http://example.com"}
+      """
+    Then the response code should be 409 (Conflict)
+    And no "audit" record exists with id "1"
+
+  Scenario: Filter and page through the audit log
+    Given these "audit" records exist:
+      | namespace | key | action | actor     | created_at           |
+      | default   | a   | create | api_key:2 | 2009-11-01T00:00:00Z |
+      | default   | a   | update | api_key:3 | 2009-11-02T00:00:00Z |
+      | default   | b   | create | api_key:2 | 2009-11-03T00:00:00Z |
+      | team-a    | a   | create | api_key:2 | 2009-11-04T00:00:00Z |
+    When the client does a GET request to "/audit?namespace=default&actor=api_key:2&limit=1"
+    Then the response code should be 200 (OK)
+    And the response JSON field "items.0.key" should be "b"
+    And the response JSON field "has_more" should be "true"
+    And the response JSON field "next_cursor" is saved as "cursor"
+    When the client does a GET request to "/audit?namespace=default&actor=api_key:2&limit=1&cursor={{cursor}}"
+    Then the response JSON field "items.0.key" should be "a"
+    And the response JSON field "items.0.action" should be "create"
+    And the response JSON field "has_more" should be "false"
+    When the client does a GET request to "/audit?key=a&created_after=2009-11-02T00:00:00Z"
+    Then the response JSON field "items.0.namespace" should be "team-a"
+    And the response JSON field "items.1.action" should be "update"
+    And the response JSON field "items.2" should be not set
+
+  Scenario: Fail to filter the audit log on an unknown action
+    When the client does a GET request to "/audit?action=rename"
+    Then the response should be a problem with status 400 (Bad Request)
+    And the problem detail should be "action must be one of create, update, delete, restore or purge"
+
+  Scenario: Only admins can read the audit log
+    Given the client authenticates as "alice" with role "editor"
+    When the client does a GET request to "/audit"
+    Then the response should be a problem with status 403 (Forbidden)
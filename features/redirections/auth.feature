@@ -0,0 +1,88 @@
+Feature: Authentication
+
+  Managing redirections requires an API key as bearer token, redirects and
+  the health check are public. The scenarios are authenticated with a test
+  key unless the Authorization header is changed.
+
+  Scenario: Fail to create a redirection without credentials
+    Given the client stops sending the header "Authorization"
+    When the client does a POST request to "/redirections" with the following data:
+      """json
+      {
+        "key": "rickroll",
+        "url": "https://www.youtube.com/watch?v=dQw4w9WgXcQ"
+      }
+      """
+    Then the response should be a problem with status 401 (Unauthorized)
+    And the problem detail should be "authentication required"
+    And the response header "WWW-Authenticate" should be "Bearer"
+    And no "redirection" record exists with key "rickroll"
+
+  Scenario: Fail to delete a redirection with an invalid key
+    Given the follow "redirection" record exist:
+      | key | test               |
+      | url | http://example.com |
+    And the client sends the header "Authorization" with "Bearer rk_invalid"
+    When the client does a DELETE request to "/redirections/test"
+    Then the response should be a problem with status 401 (Unauthorized)
+    And the problem detail should be "invalid credentials"
+    And this "redirection" record exists:
+      | key | test |
+
+  Scenario: Redirects and the health check are public
+    Given the follow "redirection" record exist:
+      | key | test               |
+      | url | http://example.com |
+    And the client stops sending the header "Authorization"
+    When the client does a GET request to "/test"
+    Then the response code should be 302 (Found)
+    When the client does a GET request to "/health"
+    Then the response code should be 200 (OK)
+
+  Scenario: Issue, use and revoke an API key
+    When the client does a POST request to "/api-keys" with the following data:
+      """json
+      {
+        "name": "deploy",
+        "role": "admin",
+        "namespaces": ["team-a"]
+      }
+      """
+    Then the response code should be 201 (Created)
+    And the response header "Location" should be "/api-keys/2"
+    And the response JSON field "name" should be "deploy"
+    And the response JSON field "role" should be "admin"
+    And the response JSON field "namespaces.0" should be "team-a"
+    And the response JSON field "key" should match "^rk_[0-9a-f]{48}$"
+    And the response JSON field "key" is saved as "key"
+    And this "api_key" record exists:
+      | id         | 2          |
+      | name       | deploy     |
+      | role       | admin      |
+      | namespaces | ["team-a"] |
+    Given the client sends the header "Authorization" with "Bearer {{key}}"
+    When the client does a GET request to "/api-keys"
+    Then the response code should be 200 (OK)
+    And the response JSON field "items.1.name" should be "deploy"
+    And the response JSON field "items.1.key" should be not set
+    When the client does a DELETE request to "/api-keys/2"
+    Then the response code should be 204 (No Content)
+    When the client does a GET request to "/api-keys"
+    Then the response should be a problem with status 401 (Unauthorized)
+    And the problem detail should be "invalid credentials"
+
+  Scenario: Fail to issue an API key with an existing name
+    When the client does a POST request to "/api-keys" with the following data:
+      """json
+      {
+        "name": "test",
+        "role": "viewer"
+      }
+      """
+    Then the response should be a problem with status 409 (Conflict)
+    And the problem should have a field error for "name" saying "already exists"
+
+  Scenario: Fail to revoke a non-existing API key
+    When the client does a DELETE request to "/api-keys/42"
+    Then the response should be a problem with status 404 (Not Found)
+    And the problem detail should be "api key not found"
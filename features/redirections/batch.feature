@@ -0,0 +1,119 @@
+Feature: Batch changes
+
+  Several redirections can be created, updated and deleted at once. The
+  operations of a batch run in a single transaction, by default nothing is
+  changed when any of them fails.
+
+  Background:
+    Given these "redirection" records exist:
+      | key     | url                 | owner     |
+      | old     | https://example.com | api_key:1 |
+      | current | https://example.com | api_key:1 |
+
+  Scenario: Create, update and delete redirections at once
+    When the client does a POST request to "/redirections/batch" with the following data:
+      """json
+      {
+        "operations": [
+          {"op": "create", "data": {"key": "new", "url": "https://example.org/new"}},
+          {"op": "update", "key": "current", "data": {"status": 301}},
+          {"op": "delete", "key": "old"}
+        ]
+      }
+      """
+    Then the response code should be 200 (OK)
+    And the response JSON field "committed" should be "true"
+    And the response JSON field "succeeded" should be "3"
+    And the response JSON field "results.0.status" should be "201"
+    And the response JSON field "results.0.redirection.url" should be "https://example.org/new"
+    And the response JSON field "results.1.redirection.status" should be "301"
+    And the response JSON field "results.2.status" should be "204"
+    And this "redirection" record exists:
+      | key | new |
+    And this "redirection" record exists:
+      | key    | current |
+      | status | 301     |
+    And this "redirection" record exists:
+      | key        | old                  |
+      | deleted_at | 2009-11-10T23:00:00Z |
+    And this "audit" record exists:
+      | id     | 3      |
+      | key    | old    |
+      | action | delete |
+
+  Scenario: Roll back the batch when an operation fails
+    When the client does a POST request to "/redirections/batch" with the following data:
+      """json
+      {
+        "operations": [
+          {"op": "create", "data": {"key": "new", "url": "https://example.org/new"}},
+          {"op": "create", "data": {"key": "current", "url": "https://example.org"}},
+          {"op": "update", "key": "missing", "data": {"status": 301}},
+          {"op": "delete", "key": "old"}
+        ]
+      }
+      """
+    Then the response code should be 422 (Unprocessable Entity)
+    And the response JSON field "committed" should be "false"
+    And the response JSON field "succeeded" should be "2"
+    And the response JSON field "failed" should be "2"
+    And the response JSON field "results.1.status" should be "409"
+    And the response JSON field "results.1.problem.detail" should be "a redirection with this key already exists"
+    And the response JSON field "results.2.problem.detail" should be "redirection not found"
+    And no "redirection" record exists with key "new"
+    And this "redirection" record exists:
+      | key        | old   |
+      | deleted_at | <nil> |
+    And no "audit" record exists with id "1"
+
+  Scenario: Keep the operations that succeeded with best effort
+    When the client does a POST request to "/redirections/batch" with the following data:
+      """json
+      {
+        "mode": "best_effort",
+        "operations": [
+          {"op": "create", "data": {"key": "new", "url": "https://example.org/new"}},
+          {"op": "update", "key": "current", "data": {"url": "ftp://example.org"}}
+        ]
+      }
+      """
+    Then the response code should be 200 (OK)
+    And the response JSON field "committed" should be "true"
+    And the response JSON field "results.1.status" should be "400"
+    And the response JSON field "results.1.problem.errors.0.field" should be "url"
+    And this "redirection" record exists:
+      | key | new |
+
+  Scenario: Only change owned redirections in a batch
+    Given the client authenticates as "alice" with role "editor"
+    When the client does a POST request to "/redirections/batch" with the following data:
+      """json
+      {"operations": [{"op": "delete", "key": "old"}]}
+      """
+    Then the response code should be 422 (Unprocessable Entity)
+    And the response JSON field "results.0.status" should be "403"
+    And the response JSON field "results.0.problem.detail" should be "the redirection is owned by someone else"
+
+  Scenario: Fail to execute an invalid batch
+    When the client does a POST request to "/redirections/batch" with the following data:
+      """json
+      {
+        "mode": "sometimes",
+        "operations": [
+          {"op": "rename", "key": "old"},
+          {"op": "delete"}
+        ]
+      }
+      """
+    Then the response should be a problem with status 400 (Bad Request)
+    And the problem should have a field error for "mode" saying "must be atomic or best_effort"
+    And the problem should have a field error for "operations.0.op" saying "must be one of create, update or delete"
+    And the problem should have a field error for "operations.1.key" saying "is required"
+
+  Scenario: Fail to execute an empty batch
+    When the client does a POST request to "/redirections/batch" with the following data:
+      """json
+      {"operations": []}
+      """
+    Then the response should be a problem with status 400 (Bad Request)
+    And the problem should have a field error for "operations" saying "must have between 1 and 100 operations"
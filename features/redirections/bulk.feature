@@ -0,0 +1,202 @@
+Feature: Import and export
+
+  Redirections can be imported and exported in bulk as JSON Lines or CSV. An
+  import runs in a single transaction, when any row fails nothing is
+  imported. Existing keys fail the import, are skipped or are updated
+  depending on the mode.
+
+  Scenario: Import redirections from JSON Lines
+    Given the client sends the header "Content-Type" with "application/x-ndjson"
+    When the client does a POST request to "/redirections:import" with the following data:
+      """
+      {"key": "one", "url": "https://example.com/1"}
+
+      {"key": "two", "url": "https://example.com/2", "status": 301, "utm": {"source": "import"}}
+      """
+    Then the response code should be 200 (OK)
+    And the response JSON field "committed" should be "true"
+    And the response JSON field "created" should be "2"
+    And the response JSON field "rows.1.row" should be "2"
+    And the response JSON field "rows.1.key" should be "two"
+    And the response JSON field "rows.1.result" should be "created"
+    And this "redirection" record exists:
+      | key    | two                   |
+      | url    | https://example.com/2 |
+      | status | 301                   |
+      | owner  | api_key:1             |
+    And this "audit" record exists:
+      | id     | 2      |
+      | key    | two    |
+      | action | create |
+
+  Scenario: Import redirections from CSV
+    Given the client sends the header "Content-Type" with "text/csv"
+    When the client does a POST request to "/redirections:import" with the following data:
+      """
+      key,url,forward_path,utm_source,owner
+      one,https://example.com/1,true,import,someone
+      ,https://example.com/2,,,
+      """
+    Then the response code should be 200 (OK)
+    And the response JSON field "created" should be "2"
+    And the response JSON field "rows.1.key" should match "^[a-zA-Z0-9]+$"
+    And this "redirection" record exists:
+      | key          | one                   |
+      | url          | https://example.com/1 |
+      | forward_path | 1                     |
+      | utm          | {"source":"import"}   |
+      | owner        | api_key:1             |
+
+  Scenario: Fail an import when any row fails
+    Given these "redirection" records exist:
+      | key      | url                 |
+      | existing | https://example.com |
+    When the client does a POST request to "/redirections:import" with the following data:
+      """
+      {"key": "new", "url": "https://example.com/new"}
+      {"key": "existing", "url": "https://example.org"}
+      {"key": "invalid", "url": "ftp://example.com"}
+      not json
+      """
+    Then the response code should be 422 (Unprocessable Entity)
+    And the response JSON field "committed" should be "false"
+    And the response JSON field "created" should be "1"
+    And the response JSON field "failed" should be "3"
+    And the response JSON field "rows.1.detail" should be "a redirection with this key already exists"
+    And the response JSON field "rows.2.errors.0.field" should be "url"
+    And the response JSON field "rows.3.detail" should match "^row must be valid JSON"
+    And no "redirection" record exists with key "new"
+
+  Scenario Outline: Import an existing key in <mode> mode
+    Given these "redirection" records exist:
+      | key      | url                 | owner     |
+      | existing | https://example.com | api_key:1 |
+    When the client does a POST request to "/redirections:import?mode=<mode>" with the following data:
+      """
+      {"key": "existing", "url": "https://example.org"}
+      """
+    Then the response code should be <status> (<reason>)
+    And the response JSON field "rows.0.result" should be "<result>"
+    And this "redirection" record exists:
+      | key | existing |
+      | url | <url>    |
+
+    Examples:
+      | mode   | status | reason               | result  | url                 |
+      | fail   | 422    | Unprocessable Entity | failed  | https://example.com |
+      | skip   | 200    | OK                   | skipped | https://example.com |
+      | upsert | 200    | OK                   | updated | https://example.org |
+
+  Scenario: Don't upsert the redirections of someone else
+    Given the client authenticates as "alice" with role "editor"
+    And these "redirection" records exist:
+      | key    | url                 | owner     |
+      | theirs | https://example.com | api_key:3 |
+    When the client does a POST request to "/redirections:import?mode=upsert" with the following data:
+      """
+      {"key": "theirs", "url": "https://example.org"}
+      """
+    Then the response code should be 422 (Unprocessable Entity)
+    And the response JSON field "rows.0.detail" should be "the redirection is owned by someone else"
+
+  Scenario: Dry run an import
+    When the client does a POST request to "/redirections:import?dry_run=true" with the following data:
+      """
+      {"key": "one", "url": "https://example.com/1"}
+      """
+    Then the response code should be 200 (OK)
+    And the response JSON field "dry_run" should be "true"
+    And the response JSON field "committed" should be "false"
+    And the response JSON field "rows.0.result" should be "created"
+    And no "redirection" record exists with key "one"
+
+  Scenario: Fail to import an unsupported format
+    Given the client sends the header "Content-Type" with "application/xml"
+    When the client does a POST request to "/redirections:import" with the following data:
+      """
+      <redirection/>
+      """
+    Then the response should be a problem with status 415 (Unsupported Media Type)
+    And the problem detail should be "content type must be application/x-ndjson or text/csv"
+
+  Scenario: Fail to import a CSV with an unknown column
+    Given the client sends the header "Content-Type" with "text/csv"
+    When the client does a POST request to "/redirections:import" with the following data:
+      """
+      key,target
+      one,https://example.com/1
+      """
+    Then the response should be a problem with status 400 (Bad Request)
+    And the problem detail should be "the CSV column target is unknown"
+
+  Scenario: Fail to import more rows than allowed
+    Given the config "IMPORT_MAX_ROWS" is "1"
+    When the client does a POST request to "/redirections:import" with the following data:
+      """
+      {"key": "one", "url": "https://example.com/1"}
+      {"key": "two", "url": "https://example.com/2"}
+      """
+    Then the response should be a problem with status 413 (Request Entity Too Large)
+    And the problem detail should be "the import must have at most 1 rows"
+    And no "redirection" record exists with key "one"
+
+  Scenario: Fail to import a body larger than allowed
+    Given the config "IMPORT_MAX_BYTES" is "32"
+    When the client does a POST request to "/redirections:import" with the following data:
+      """
+      {"key": "one", "url": "https://example.com/1"}
+      """
+    Then the response should be a problem with status 413 (Request Entity Too Large)
+    And the problem detail should be "the import must be at most 32 bytes"
+    And no "redirection" record exists with key "one"
+
+  Scenario: Export redirections as JSON Lines
+    Given these "redirection" records exist:
+      | key | url                   | owner     | created_at           | updated_at           |
+      | b   | https://example.com/b | api_key:1 | 2009-11-10T23:00:00Z | 2009-11-10T23:00:00Z |
+      | a   | https://example.com/a | api_key:1 | 2009-11-10T23:00:00Z | 2009-11-10T23:00:00Z |
+    When the client does a GET request to "/redirections:export"
+    Then the response code should be 200 (OK)
+    And the response body should be the following "application/x-ndjson" lines:
+      """
+      {"namespace":"default","key":"a","url":"https://example.com/a","owner":"api_key:1","created_at":"2009-11-10T23:00:00Z","updated_at":"2009-11-10T23:00:00Z","version":1}
+      {"namespace":"default","key":"b","url":"https://example.com/b","owner":"api_key:1","created_at":"2009-11-10T23:00:00Z","updated_at":"2009-11-10T23:00:00Z","version":1}
+      """
+
+  Scenario: Export redirections as CSV
+    Given these "redirection" records exist:
+      | key | url                   | status | owner     | utm                 | created_at           | updated_at           |
+      | a   | https://example.com/a | 301    | api_key:1 | {"source":"export"} | 2009-11-10T23:00:00Z | 2009-11-10T23:00:00Z |
+    And the client sends the header "Accept" with "text/csv"
+    When the client does a GET request to "/redirections:export"
+    Then the response code should be 200 (OK)
+    And the response body should be the following "text/csv" lines:
+      """
+      key,url,status,expires_at,forward_path,forward_query,query_precedence,utm_source,utm_medium,utm_campaign,utm_term,utm_content,owner,created_at,updated_at
+      a,https://example.com/a,301,,false,false,,export,,,,,api_key:1,2009-11-10T23:00:00Z,2009-11-10T23:00:00Z
+      """
+
+  Scenario: Import an export again without the expired redirections
+    Given these "redirection" records exist:
+      | key     | url                         | owner     | expires_at           | created_at           | updated_at           |
+      | active  | https://example.com/active  | api_key:1 | 2009-11-11T23:00:00Z | 2009-11-10T23:00:00Z | 2009-11-10T23:00:00Z |
+      | expired | https://example.com/expired | api_key:1 | 2009-11-10T22:00:00Z | 2009-11-10T22:00:00Z | 2009-11-10T22:00:00Z |
+    When the client does a GET request to "/redirections:export"
+    Then the response code should be 200 (OK)
+    And the response body should be the following "application/x-ndjson" lines:
+      """
+      {"namespace":"default","key":"active","url":"https://example.com/active","expires_at":"2009-11-11T23:00:00Z","owner":"api_key:1","created_at":"2009-11-10T23:00:00Z","updated_at":"2009-11-10T23:00:00Z","version":1}
+      """
+    Given the client sends the header "Content-Type" with "application/x-ndjson"
+    And the client sends the header "X-Namespace" with "restored"
+    When the client does a POST request to "/redirections:import" with the following data:
+      """
+      {"namespace":"default","key":"active","url":"https://example.com/active","expires_at":"2009-11-11T23:00:00Z","owner":"api_key:1","created_at":"2009-11-10T23:00:00Z","updated_at":"2009-11-10T23:00:00Z","version":1}
+      """
+    Then the response code should be 200 (OK)
+    And the response JSON field "created" should be "1"
+    And this "redirection" record exists:
+      | namespace  | restored                   |
+      | key        | active                     |
+      | url        | https://example.com/active |
+      | expires_at | 2009-11-11T23:00:00Z       |
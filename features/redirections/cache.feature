@@ -0,0 +1,135 @@
+Feature: Redirect cache
+
+  Redirects look up their redirection and the domain of their host in an
+  in-memory cache first, keys and hosts without one are cached as well but
+  shorter. Changes through the API and purges by the sweeper remove the key or
+  host from the cache.
+
+  Scenario: Serve repeated redirects from the cache
+    Given these "redirection" records exist:
+      | key  | url                 |
+      | test | https://example.com |
+    When the client does a GET request to "/test"
+    And the client does a GET request to "/test"
+    Then the response code should be 302 (Found)
+    When the client does a GET request to "/metrics"
+    Then the response JSON field "redirect_cache.hits" should be "1"
+    And the response JSON field "redirect_cache.misses" should be "1"
+    And the response JSON field "redirect_cache.size" should be "1"
+
+  Scenario: Serve cached redirects without the database
+    Given the config "DEFAULT_DOMAIN" is "go.team-a.example"
+    And these "domain" records exist:
+      | host              | namespace |
+      | go.team-a.example | team-a    |
+    And these "redirection" records exist:
+      | namespace | key   | url                 |
+      | team-a    | promo | https://example.com |
+    When the client does a GET request to "/promo" with the following headers:
+      | Host | go.team-a.example |
+    And the client does a GET request to "/promo" with the following headers:
+      | Host | unknown.example |
+    Then the response code should be 302 (Found)
+    Given the database is unavailable
+    When the client does a GET request to "/promo" with the following headers:
+      | Host | go.team-a.example |
+    Then the response code should be 302 (Found)
+    And the response header "Location" should be "https://example.com"
+    When the client does a GET request to "/promo" with the following headers:
+      | Host | unknown.example |
+    Then the response code should be 302 (Found)
+    And the response header "Location" should be "https://example.com"
+
+  Scenario: Forget cached domains when they change
+    When the client does a GET request to "/promo" with the following headers:
+      | Host | go.team-a.example |
+    Then the response code should be 404 (Not Found)
+    Given these "redirection" records exist:
+      | namespace | key   | url                 |
+      | team-a    | promo | https://example.com |
+    When the client does a POST request to "/domains" with the following data:
+      """json
+      {"host": "go.team-a.example", "namespace": "team-a"}
+      """
+    And the client does a GET request to "/promo" with the following headers:
+      | Host | go.team-a.example |
+    Then the response code should be 302 (Found)
+    When the client does a PUT request to "/domains/go.team-a.example" with the following data:
+      """json
+      {"namespace": "team-b"}
+      """
+    And the client does a GET request to "/promo" with the following headers:
+      | Host | go.team-a.example |
+    Then the response code should be 404 (Not Found)
+    When the client does a PUT request to "/domains/go.team-a.example" with the following data:
+      """json
+      {"namespace": "team-a"}
+      """
+    And the client does a GET request to "/promo" with the following headers:
+      | Host | go.team-a.example |
+    Then the response code should be 302 (Found)
+    When the client does a DELETE request to "/domains/go.team-a.example"
+    And the client does a GET request to "/promo" with the following headers:
+      | Host | go.team-a.example |
+    Then the response code should be 404 (Not Found)
+
+  Scenario: Cache keys without a redirection for a shorter time
+    Given the config "REDIRECT_CACHE_NEGATIVE_TTL" is "10s"
+    When the client does a GET request to "/test"
+    Then the response code should be 404 (Not Found)
+    Given these "redirection" records exist:
+      | key  | url                 |
+      | test | https://example.com |
+    When the client does a GET request to "/test"
+    Then the response code should be 404 (Not Found)
+    Given the current time is "2009-11-10T23:00:10Z"
+    When the client does a GET request to "/test"
+    Then the response code should be 302 (Found)
+
+  Scenario: Forget cached redirections when they change
+    When the client does a GET request to "/test"
+    Then the response code should be 404 (Not Found)
+    When the client does a POST request to "/redirections" with the following data:
+      """json
+      {"key": "test", "url": "https://example.com"}
+      """
+    And the client does a GET request to "/test"
+    Then the response code should be 302 (Found)
+    And the response header "Location" should be "https://example.com"
+    When the client does a PATCH request to "/redirections/test" with the following data:
+      """json
+      {"url": "https://example.org"}
+      """
+    And the client does a GET request to "/test"
+    Then the response header "Location" should be "https://example.org"
+    When the client does a DELETE request to "/redirections/test"
+    And the client does a GET request to "/test"
+    Then the response code should be 404 (Not Found)
+    When the client does a POST request to "/redirections/batch" with the following data:
+      """json
+      {"operations": [{"op": "create", "data": {"key": "test", "url": "https://example.net"}}]}
+      """
+    And the client does a GET request to "/test"
+    Then the response header "Location" should be "https://example.net"
+
+  Scenario: Forget cached redirections when they are purged
+    Given the config "EXPIRED_RETENTION" is "1h"
+    And these "redirection" records exist:
+      | key  | url                 | expires_at           |
+      | test | https://example.com | 2009-11-10T21:00:00Z |
+    When the client does a GET request to "/test"
+    Then the response should be a problem with status 410 (Gone)
+    When the sweeper has run
+    And the client does a GET request to "/test"
+    Then the response should be a problem with status 404 (Not Found)
+
+  Scenario: Disable the cache
+    Given the config "REDIRECT_CACHE_SIZE" is "0"
+    And these "redirection" records exist:
+      | key  | url                 |
+      | test | https://example.com |
+    When the client does a GET request to "/test"
+    And the client does a GET request to "/test"
+    And the client does a GET request to "/metrics"
+    Then the response JSON field "redirect_cache.hits" should be "0"
+    And the response JSON field "redirect_cache.size" should be "0"
@@ -0,0 +1,122 @@
+Feature: Conditional requests
+
+  Every change of a redirection increments its version, which together with
+  its creation time (in hexadecimal nanoseconds) is the ETag of the
+  redirection. Changes with an If-Match header fail when the redirection has
+  changed in the meantime, reads with If-None-Match are answered with 304 Not
+  Modified when it hasn't.
+
+  Background:
+    Given these "redirection" records exist:
+      | key  | url                 | version | created_at           |
+      | test | https://example.com | 3       | 2009-11-10T23:00:00Z |
+
+  Scenario: Get the ETag of a redirection
+    When the client does a GET request to "/redirections/test"
+    Then the response code should be 200 (OK)
+    And the response header "ETag" should be ""1174efedab186000-3""
+    And the response JSON field "version" should be "3"
+
+  Scenario: Don't send a redirection that isn't modified
+    Given the client sends the header "If-None-Match" with ""1174efedab186000-2", "1174efedab186000-3""
+    When the client does a GET request to "/redirections/test"
+    Then the response code should be 304 (Not Modified)
+    And the response header "ETag" should be ""1174efedab186000-3""
+    And the response body should be empty
+
+  Scenario: Update a redirection that hasn't changed
+    Given the client sends the header "If-Match" with ""1174efedab186000-3""
+    When the client does a PATCH request to "/redirections/test" with the following data:
+      """json
+      {"url": "https://example.org"}
+      """
+    Then the response code should be 200 (OK)
+    And the response header "ETag" should be ""1174efedab186000-4""
+    And this "redirection" record exists:
+      | key     | test                |
+      | url     | https://example.org |
+      | version | 4                   |
+
+  Scenario Outline: Fail to <method> a redirection that has changed
+    Given the client sends the header "If-Match" with ""1174efedab186000-2""
+    When the client does a <method> request to "/redirections/test" with the following data:
+      """json
+      {"url": "https://example.org"}
+      """
+    Then the response should be a problem with status 412 (Precondition Failed)
+    And the problem detail should be "the redirection has been changed since it was read"
+    And this "redirection" record exists:
+      | key        | test                |
+      | url        | https://example.com |
+      | version    | 3                   |
+      | deleted_at | <nil>               |
+
+    Examples:
+      | method |
+      | PUT    |
+      | PATCH  |
+      | DELETE |
+
+  Scenario: Fail to delete a redirection that doesn't exist anymore
+    Given the client sends the header "If-Match" with ""1174efedab186000-1""
+    When the client does a DELETE request to "/redirections/gone"
+    Then the response should be a problem with status 412 (Precondition Failed)
+
+  Scenario: Fail to change a redirection without If-Match when it's required
+    Given the config "REQUIRE_IF_MATCH" is "true"
+    And these "redirection" records exist:
+      | key  | url                 |
+      | test | https://example.com |
+    When the client does a DELETE request to "/redirections/test"
+    Then the response should be a problem with status 428 (Precondition Required)
+    And the problem detail should be "the If-Match header is required"
+    When the client does a POST request to "/redirections/batch" with the following data:
+      """json
+      {"operations": [{"op": "delete", "key": "test"}]}
+      """
+    Then the response code should be 422 (Unprocessable Entity)
+    And the response JSON field "results.0.status" should be "428"
+
+  Scenario: Fail to change a redirection created again with an old ETag
+    Given the client does a DELETE request to "/redirections/test"
+    And the current time is "2009-11-10T23:00:01Z"
+    When the client does a POST request to "/redirections" with the following data:
+      """json
+      {"key": "test", "url": "https://example.org", "status": 301}
+      """
+    And the client does a PATCH request to "/redirections/test" with the following data:
+      """json
+      {"url": "https://example.net"}
+      """
+    And the client does a PATCH request to "/redirections/test" with the following data:
+      """json
+      {"url": "https://example.com"}
+      """
+    Then the response JSON field "version" should be "3"
+    Given the client sends the header "If-Match" with ""1174efedab186000-3""
+    When the client does a DELETE request to "/redirections/test"
+    Then the response should be a problem with status 412 (Precondition Failed)
+
+  Scenario: Fail to upsert an import when If-Match is required
+    Given the config "REQUIRE_IF_MATCH" is "true"
+    When the client does a POST request to "/redirections:import?mode=upsert" with the following data:
+      """
+      {"key": "test", "url": "https://example.org"}
+      """
+    Then the response should be a problem with status 428 (Precondition Required)
+    And the problem detail should be "mode upsert is not allowed when If-Match is required"
+
+  Scenario: Check the if_match of batch operations
+    When the client does a POST request to "/redirections/batch" with the following data:
+      """json
+      {
+        "mode": "best_effort",
+        "operations": [
+          {"op": "update", "key": "test", "if_match": "\"1174efedab186000-3\"", "data": {"status": 301}},
+          {"op": "delete", "key": "test", "if_match": "\"1174efedab186000-3\""}
+        ]
+      }
+      """
+    Then the response code should be 200 (OK)
+    And the response JSON field "results.0.redirection.version" should be "4"
+    And the response JSON field "results.1.status" should be "412"
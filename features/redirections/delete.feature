@@ -0,0 +1,117 @@
+Feature: Deleting and restoring redirections
+
+  Deleted redirections stop redirecting but are kept for the configured
+  retention, in the meantime they can be restored. After that the sweeper
+  purges them.
+
+  Background:
+    Given these "redirection" records exist:
+      | key   | url                | owner     |
+      | test  | http://example.com | api_key:1 |
+      | other | http://example.org | api_key:1 |
+
+  Scenario: A deleted redirection no longer redirects
+    When the client does a DELETE request to "/redirections/test"
+    Then the response code should be 204 (No Content)
+    When the client does a GET request to "/test"
+    Then the response code should be 404 (Not Found)
+    When the client does a GET request to "/redirections/test"
+    Then the response should be a problem with status 404 (Not Found)
+
+  Scenario: Restore a deleted redirection
+    Given the client does a DELETE request to "/redirections/test"
+    When the client does a POST request to "/redirections/test/restore"
+    Then the response code should be 200 (OK)
+    And the response JSON field "key" should be "test"
+    And the response JSON field "deleted_at" should be not set
+    And this "redirection" record exists:
+      | key        | test  |
+      | deleted_at | <nil> |
+    And this "audit" record exists:
+      | id     | 2       |
+      | key    | test    |
+      | action | restore |
+    When the client does a GET request to "/test"
+    Then the response code should be 302 (Found)
+
+  Scenario: Fail to restore a redirection that is not deleted
+    When the client does a POST request to "/redirections/test/restore"
+    Then the response should be a problem with status 404 (Not Found)
+    And the problem detail should be "deleted redirection not found"
+
+  Scenario: Fail to restore a redirection when the namespace is full
+    Given these "namespace" records exist:
+      | name    | max_redirections |
+      | default | 1                |
+    And the client does a DELETE request to "/redirections/test"
+    When the client does a POST request to "/redirections/test/restore"
+    Then the response should be a problem with status 403 (Forbidden)
+    And the problem detail should be "the quota of namespace default is exceeded"
+
+  Scenario: List deleted redirections on request
+    Given the client does a DELETE request to "/redirections/test"
+    When the client does a GET request to "/redirections"
+    Then the response JSON field "items.0.key" should be "other"
+    And the response JSON field "items.1" should be not set
+    When the client does a GET request to "/redirections?include_deleted=true"
+    Then the response JSON field "items.1.key" should be "test"
+    And the response JSON field "items.1.deleted_at" should be "2009-11-10T23:00:00Z"
+
+  Scenario: Creating a redirection with the key of a deleted one purges it
+    Given the client does a DELETE request to "/redirections/test"
+    When the client does a POST request to "/redirections" with the following data:
+      """json
+      {"key": "test", "url": "http://example.net"}
+      """
+    Then the response code should be 201 (Created)
+    And this "audit" record exists:
+      | id     | 2     |
+      | key    | test  |
+      | action | purge |
+    When the client does a POST request to "/redirections/test/restore"
+    Then the response should be a problem with status 404 (Not Found)
+
+  Scenario: Fail to create a redirection with the key of someone else's deleted one
+    Given the client does a DELETE request to "/redirections/test"
+    And the client authenticates as "alice" with role "editor"
+    When the client does a POST request to "/redirections" with the following data:
+      """json
+      {"key": "test", "url": "http://example.net"}
+      """
+    Then the response should be a problem with status 409 (Conflict)
+    And this "redirection" record exists:
+      | key        | test                 |
+      | url        | http://example.com   |
+      | deleted_at | 2009-11-10T23:00:00Z |
+
+  Scenario: Don't purge a deleted redirection when the namespace is full
+    Given the config "NAMESPACE_QUOTA" is "1"
+    And these "redirection" records exist:
+      | key  | url                | owner     | deleted_at           |
+      | test | http://example.com | api_key:1 | 2009-11-10T22:00:00Z |
+      | full | http://example.org | api_key:1 | <nil>                |
+    When the client does a POST request to "/redirections" with the following data:
+      """json
+      {"key": "test", "url": "http://example.net"}
+      """
+    Then the response should be a problem with status 403 (Forbidden)
+    And this "redirection" record exists:
+      | key        | test                 |
+      | deleted_at | 2009-11-10T22:00:00Z |
+
+  Scenario: Purge redirections deleted longer than the retention ago
+    Given the config "DELETED_RETENTION" is "24h"
+    And these "redirection" records exist:
+      | key    | url                | deleted_at           |
+      | old    | http://example.com | 2009-11-09T22:00:00Z |
+      | recent | http://example.com | 2009-11-10T22:00:00Z |
+    When the sweeper has run
+    Then no "redirection" record exists with key "old"
+    And this "redirection" record exists:
+      | key | recent |
+    When the client does a GET request to "/audit?action=purge"
+    Then the response JSON field "items.0.key" should be "old"
+    And the response JSON field "items.0.actor" should be not set
+    And the response JSON field "items.0.before.deleted_at" should be "2009-11-09T22:00:00Z"
+    And the response JSON field "items.0.after" should be "<nil>"
+    And the response JSON field "items.1" should be not set
@@ -0,0 +1,139 @@
+Feature: Custom domains
+
+  The service redirects on several domains, each resolving the keys in its
+  own namespace. Domains can send keys that don't exist to a not found URL.
+
+  Scenario: Create a domain
+    When the client does a POST request to "/domains" with the following data:
+      """json
+      {
+        "host": "Go.Team-A.Example:443",
+        "namespace": "team-a",
+        "not_found_url": "https://team-a.example/missing"
+      }
+      """
+    Then the response code should be 201 (Created)
+    And the response header "Location" should be "/domains/go.team-a.example"
+    And the response JSON field "host" should be "go.team-a.example"
+    And this "domain" record exists:
+      | host          | go.team-a.example              |
+      | namespace     | team-a                         |
+      | not_found_url | https://team-a.example/missing |
+
+  Scenario: Redirect by host and key
+    Given these "domain" records exist:
+      | host              | namespace |
+      | go.team-a.example | team-a    |
+    And these "redirection" records exist:
+      | namespace | key   | url                    |
+      | team-a    | promo | https://a.example.com/ |
+      | default   | promo | https://example.com/   |
+    When the client does a GET request to "/promo" with the following headers:
+      | Host | GO.team-a.example |
+    Then the response code should be 302 (Found)
+    And the response header "Location" should be "https://a.example.com/"
+    When the client does a GET request to "/promo" with the following headers:
+      | Host | unknown.example |
+    Then the response header "Location" should be "https://example.com/"
+
+  Scenario: Domains don't resolve the keys of other namespaces
+    Given these "domain" records exist:
+      | host              | namespace |
+      | go.team-a.example | team-a    |
+    And these "redirection" records exist:
+      | namespace | key   | url                    |
+      | team-b    | promo | https://b.example.com/ |
+    When the client does a GET request to "/~team-b/promo" with the following headers:
+      | Host | go.team-a.example |
+    Then the response should be a problem with status 404 (Not Found)
+    When the client does a GET request to "/~team-b/promo" with the following headers:
+      | Host | unknown.example |
+    Then the response code should be 302 (Found)
+    And the response header "Location" should be "https://b.example.com/"
+
+  Scenario: Send unknown keys to the not found URL of the domain
+    Given these "domain" records exist:
+      | host              | namespace | not_found_url                  |
+      | go.team-a.example | team-a    | https://team-a.example/missing |
+    When the client does a GET request to "/nope" with the following headers:
+      | Host | go.team-a.example |
+    Then the response code should be 302 (Found)
+    And the response header "Location" should be "https://team-a.example/missing"
+    When the client does a GET request to "/nope"
+    Then the response should be a problem with status 404 (Not Found)
+
+  Scenario: Fall back to the default domain
+    Given the config "DEFAULT_DOMAIN" is "go.team-a.example"
+    And these "domain" records exist:
+      | host              | namespace | not_found_url                  |
+      | go.team-a.example | team-a    | https://team-a.example/missing |
+      | go.team-b.example | team-b    |                                |
+    And these "redirection" records exist:
+      | namespace | key   | url                    |
+      | team-a    | promo | https://a.example.com/ |
+      | team-b    | promo | https://b.example.com/ |
+    When the client does a GET request to "/promo" with the following headers:
+      | Host | unknown.example |
+    Then the response header "Location" should be "https://a.example.com/"
+    When the client does a GET request to "/promo" with the following headers:
+      | Host | go.team-b.example |
+    Then the response header "Location" should be "https://b.example.com/"
+    When the client does a GET request to "/nope" with the following headers:
+      | Host | go.team-b.example |
+    Then the response should be a problem with status 404 (Not Found)
+    When the client does a GET request to "/nope" with the following headers:
+      | Host | unknown.example |
+    Then the response header "Location" should be "https://team-a.example/missing"
+
+  Scenario: Replace a domain
+    Given these "domain" records exist:
+      | host              | namespace |
+      | go.team-a.example | team-a    |
+    When the client does a PUT request to "/domains/go.team-a.example" with the following data:
+      """json
+      { "namespace": "team-b" }
+      """
+    Then the response code should be 200 (OK)
+    And the response JSON field "namespace" should be "team-b"
+    And the response JSON field "not_found_url" should be not set
+
+  Scenario: List and delete domains
+    Given these "domain" records exist:
+      | host              | namespace |
+      | go.team-b.example | team-b    |
+      | go.team-a.example | team-a    |
+    When the client does a GET request to "/domains"
+    Then the response JSON field "items.0.host" should be "go.team-a.example"
+    And the response JSON field "items.1.host" should be "go.team-b.example"
+    When the client does a DELETE request to "/domains/go.team-a.example"
+    Then the response code should be 204 (No Content)
+    And no "domain" record exists with host "go.team-a.example"
+    When the client does a GET request to "/domains/go.team-a.example"
+    Then the response should be a problem with status 404 (Not Found)
+    And the problem detail should be "domain not found"
+
+  Scenario: Fail to create a domain with an existing host
+    Given these "domain" records exist:
+      | host              | namespace |
+      | go.team-a.example | team-a    |
+    When the client does a POST request to "/domains" with the following data:
+      """json
+      { "host": "go.team-a.example" }
+      """
+    Then the response should be a problem with status 409 (Conflict)
+    And the problem should have a field error for "host" saying "already exists"
+
+  Scenario Outline: Reject invalid domains
+    When the client does a POST request to "/domains" with the following data:
+      """json
+      { "host": "<host>", "namespace": "<namespace>", "not_found_url": "<url>" }
+      """
+    Then the response should be a problem with status 400 (Bad Request)
+    And the problem should have a field error for "<field>" saying "<message>"
+
+    Examples:
+      | host          | namespace | url                 | field         | message                  |
+      |               | team-a    |                     | host          | is required              |
+      | go_a.example  | team-a    |                     | host          | is not a valid host name |
+      | go.example    | Team A    |                     | namespace     | is not a valid namespace |
+      | go.example    | team-a    | ftp://example.com/  | not_found_url | scheme ftp is not allowed |
@@ -0,0 +1,155 @@
+Feature: Expiring redirections
+
+  Redirections can be given an absolute expiry or a ttl, after which they are
+  gone. Expired redirections are purged by a background sweeper.
+
+  Scenario: Create a redirection with a ttl
+    When the client does a POST request to "/redirections" with the following data:
+      """json
+      {
+        "key": "campaign",
+        "url": "https://example.com/",
+        "ttl": "48h"
+      }
+      """
+    Then the response code should be 201 (Created)
+    And the response JSON field "expires_at" should be "2009-11-12T23:00:00Z"
+    And this "redirection" record exists:
+      | key        | campaign             |
+      | expires_at | 2009-11-12T23:00:00Z |
+
+  Scenario: Create a redirection with an absolute expiry
+    When the client does a POST request to "/redirections" with the following data:
+      """json
+      {
+        "key": "campaign",
+        "url": "https://example.com/",
+        "expires_at": "2009-12-01T00:00:00Z"
+      }
+      """
+    Then the response code should be 201 (Created)
+    And the response JSON field "expires_at" should be "2009-12-01T00:00:00Z"
+
+  Scenario Outline: Reject invalid expiries
+    When the client does a POST request to "/redirections" with the following data:
+      """json
+      {
+        "key": "campaign",
+        "url": "https://example.com/",
+        <fields>
+      }
+      """
+    Then the response should be a problem with status 400 (Bad Request)
+    And the problem should have a field error for "<field>" saying "<message>"
+
+    Examples:
+      | fields                                              | field      | message                                |
+      | "ttl": "soon"                                       | ttl        | must be a positive duration, e.g. 24h  |
+      | "ttl": "-1h"                                        | ttl        | must be a positive duration, e.g. 24h  |
+      | "expires_at": "2009-01-01T00:00:00Z"                | expires_at | must be in the future                  |
+      | "ttl": "1h", "expires_at": "2009-12-01T00:00:00Z"   | ttl        | can not be combined with expires_at    |
+
+  Scenario: Redirect until the redirection expires
+    Given the follow "redirection" record exist:
+      | key        | campaign             |
+      | url        | https://example.com/ |
+      | expires_at | 2009-11-11T00:00:00Z |
+    When the client does a GET request to "/campaign"
+    Then the response code should be 302 (Found)
+    Given the current time is "2009-11-11T00:00:00Z"
+    When the client does a GET request to "/campaign"
+    Then the response should be a problem with status 410 (Gone)
+    And the problem detail should be "redirection has expired"
+
+  Scenario: Extend the expiry of a redirection
+    Given the follow "redirection" record exist:
+      | key        | campaign             |
+      | url        | https://example.com/ |
+      | expires_at | 2009-11-10T23:30:00Z |
+    When the client does a PATCH request to "/redirections/campaign" with the following data:
+      """json
+      {
+        "ttl": "1h"
+      }
+      """
+    Then the response code should be 200 (OK)
+    And this "redirection" record exists:
+      | key        | campaign             |
+      | url        | https://example.com/ |
+      | expires_at | 2009-11-11T00:00:00Z |
+
+  Scenario: Remove the expiry of a redirection
+    Given the follow "redirection" record exist:
+      | key        | campaign             |
+      | url        | https://example.com/ |
+      | expires_at | 2009-11-11T00:00:00Z |
+    When the client does a PATCH request to "/redirections/campaign" with the following data:
+      """json
+      {
+        "expires_at": null
+      }
+      """
+    Then the response code should be 200 (OK)
+    And the response JSON field "expires_at" should be not set
+    And this "redirection" record exists:
+      | key        | campaign |
+      | expires_at | <nil>    |
+
+  Scenario: Replacing a redirection without expiry removes it
+    Given the follow "redirection" record exist:
+      | key        | campaign             |
+      | url        | https://example.com/ |
+      | expires_at | 2009-11-11T00:00:00Z |
+    When the client does a PUT request to "/redirections/campaign" with the following data:
+      """json
+      {
+        "url": "https://example.org/"
+      }
+      """
+    Then the response code should be 200 (OK)
+    And this "redirection" record exists:
+      | key        | campaign |
+      | expires_at | <nil>    |
+
+  Scenario: Purge redirections that expired longer than the retention ago
+    Given the config "EXPIRED_RETENTION" is "24h"
+    And these "redirection" records exist:
+      | key     | url                  | expires_at           |
+      | old     | https://example.com/ | 2009-11-09T22:00:00Z |
+      | recent  | https://example.com/ | 2009-11-10T22:00:00Z |
+      | future  | https://example.com/ | 2009-11-20T00:00:00Z |
+      | forever | https://example.com/ | <nil>                |
+    When the sweeper has run
+    Then no "redirection" record exists with key "old"
+    And this "redirection" record exists:
+      | key | recent |
+    And this "redirection" record exists:
+      | key | future |
+    And this "redirection" record exists:
+      | key | forever |
+    And this "audit" record exists:
+      | id         | 1                    |
+      | key        | old                  |
+      | action     | purge                |
+      | actor      | <nil>                |
+      | created_at | 2009-11-10T23:00:00Z |
+    When the client does a GET request to "/audit?action=purge"
+    Then the response JSON field "items.0.before.key" should be "old"
+    And the response JSON field "items.1" should be not set
+
+  Scenario: Purge expired redirections with a DSN without a time format
+    Given the config "DSN" is ":memory:"
+    And the config "EXPIRED_RETENTION" is "1h"
+    When the client does a POST request to "/redirections" with the following data:
+      """json
+      {"key": "old", "url": "https://example.com", "ttl": "1h"}
+      """
+    And the client does a POST request to "/redirections" with the following data:
+      """json
+      {"key": "recent", "url": "https://example.com", "ttl": "24h"}
+      """
+    Given the current time is "2009-11-11T01:00:00Z"
+    When the sweeper has run
+    Then no "redirection" record exists with key "old"
+    And this "redirection" record exists:
+      | key | recent |
@@ -0,0 +1,116 @@
+Feature: Forward the path and query of a redirect
+
+  Redirections can opt-in to forward the rest of the path and the query
+  parameters of the request to their URL, and inject UTM parameters.
+
+  Scenario: Ignore the query of the request by default
+    Given the follow "redirection" record exist:
+      | key | docs                         |
+      | url | https://example.com/docs?v=1 |
+    When the client does a GET request to "/docs?v=2&page=3"
+    Then the response code should be 302 (Found)
+    And the response header "Location" should be "https://example.com/docs?v=1"
+
+  Scenario: Extra path segments don't match without forwarding
+    Given the follow "redirection" record exist:
+      | key | docs                     |
+      | url | https://example.com/docs |
+    When the client does a GET request to "/docs/getting-started"
+    Then the response should be a problem with status 404 (Not Found)
+
+  Scenario: Forward the rest of the path
+    Given the follow "redirection" record exist:
+      | key          | docs                         |
+      | url          | https://example.com/docs?v=1 |
+      | forward_path | 1                            |
+    When the client does a GET request to "/docs/guides/getting-started"
+    Then the response code should be 302 (Found)
+    And the response header "Location" should be "https://example.com/docs/guides/getting-started?v=1"
+
+  Scenario Outline: Fail to forward a path that leaves the URL of the redirection
+    Given the follow "redirection" record exist:
+      | key          | docs                     |
+      | url          | https://example.com/docs |
+      | forward_path | 1                        |
+    When the client does a GET request to "<path>"
+    Then the response should be a problem with status 400 (Bad Request)
+    And the problem detail should be "the path must not have . or .. segments"
+
+    Examples:
+      | path                    |
+      | /docs/..%2F..%2Fadmin   |
+      | /docs/guides/%2E%2E     |
+      | /docs/.%2Fadmin         |
+
+  Scenario: Merge the query of the request with the target winning
+    Given the follow "redirection" record exist:
+      | key           | docs                         |
+      | url           | https://example.com/docs?v=1 |
+      | forward_query | 1                            |
+    When the client does a GET request to "/docs?v=2&page=3"
+    Then the response code should be 302 (Found)
+    And the response header "Location" should be "https://example.com/docs?page=3&v=1"
+
+  Scenario: Merge the query of the request with the request winning
+    Given the follow "redirection" record exist:
+      | key              | docs                         |
+      | url              | https://example.com/docs?v=1 |
+      | forward_query    | 1                            |
+      | query_precedence | request                      |
+    When the client does a GET request to "/docs?v=2&page=3"
+    Then the response code should be 302 (Found)
+    And the response header "Location" should be "https://example.com/docs?page=3&v=2"
+
+  Scenario: Create a forwarding redirection with UTM parameters
+    When the client does a POST request to "/redirections" with the following data:
+      """json
+      {
+        "key": "promo",
+        "url": "https://example.com/shop",
+        "forward_path": true,
+        "forward_query": true,
+        "utm": {
+          "source": "newsletter",
+          "campaign": "winter"
+        }
+      }
+      """
+    Then the response code should be 201 (Created)
+    And the response JSON field "forward_path" should be "true"
+    And the response JSON field "utm.campaign" should be "winter"
+    When the client does a GET request to "/promo/shoes?size=42&utm_source=twitter"
+    Then the response code should be 302 (Found)
+    And the response header "Location" should be "https://example.com/shop/shoes?size=42&utm_campaign=winter&utm_source=newsletter"
+
+  Scenario: Remove the UTM parameters of a redirection
+    Given the follow "redirection" record exist:
+      | key | promo                      |
+      | url | https://example.com/shop   |
+      | utm | {"source": "newsletter"}   |
+    When the client does a PATCH request to "/redirections/promo" with the following data:
+      """json
+      { "utm": null, "forward_query": true }
+      """
+    Then the response code should be 200 (OK)
+    And the response JSON field "utm" should be not set
+    And the response JSON field "forward_query" should be "true"
+    When the client does a GET request to "/promo?ref=mail"
+    Then the response header "Location" should be "https://example.com/shop?ref=mail"
+
+  Scenario Outline: Reject invalid forwarding options
+    When the client does a POST request to "/redirections" with the following data:
+      """json
+      {
+        "key": "promo",
+        "url": "https://example.com/",
+        <fields>
+      }
+      """
+    Then the response should be a problem with status 400 (Bad Request)
+    And the problem should have a field error for "<field>" saying "<message>"
+
+    Examples:
+      | fields                           | field            | message                                                   |
+      | "query_precedence": "both"       | query_precedence | must be target or request                                 |
+      | "utm": {"id": "1"}               | utm              | id is not one of source, medium, campaign, term, content  |
+      | "utm": {"source": ""}            | utm              | source can not be empty                                   |
@@ -0,0 +1,134 @@
+Feature: Idempotency keys
+
+  Changes sent with an Idempotency-Key header can safely be retried, the
+  response of the first request is replayed to retries with the same key for
+  the configured window. Issuing API keys and imports aren't idempotent.
+
+  Scenario: Replay the response to a retried create
+    Given the client sends the header "Idempotency-Key" with "create-rickroll"
+    When the client does a POST request to "/redirections" with the following data:
+      """json
+      {"key": "rickroll", "url": "https://www.youtube.com/watch?v=dQw4w9WgXcQ"}
+      """
+    Then the response code should be 201 (Created)
+    And the response header "Idempotent-Replayed" should be not set
+    When the client does a POST request to "/redirections" with the following data:
+      """json
+      {"key": "rickroll", "url": "https://www.youtube.com/watch?v=dQw4w9WgXcQ"}
+      """
+    Then the response code should be 201 (Created)
+    And the response header "Idempotent-Replayed" should be "true"
+    And the response header "Location" should be "/redirections/rickroll"
+    And the response JSON field "key" should be "rickroll"
+    And no "audit" record exists with id "2"
+
+  Scenario: Fail to reuse an idempotency key for a different request
+    Given the client sends the header "Idempotency-Key" with "create"
+    When the client does a POST request to "/redirections" with the following data:
+      """json
+      {"key": "one", "url": "https://example.com"}
+      """
+    Then the response code should be 201 (Created)
+    When the client does a POST request to "/redirections" with the following data:
+      """json
+      {"key": "two", "url": "https://example.com"}
+      """
+    Then the response should be a problem with status 422 (Unprocessable Entity)
+    And the problem detail should be "the Idempotency-Key is already used for a different request"
+    And no "redirection" record exists with key "two"
+
+  Scenario: Fail to reuse an idempotency key with different preconditions
+    Given these "redirection" records exist:
+      | key  | url                 | created_at           |
+      | test | https://example.com | 2009-11-10T23:00:00Z |
+    And the client sends the header "Idempotency-Key" with "patch"
+    And the client sends the header "If-Match" with ""1174efedab186000-1""
+    When the client does a PATCH request to "/redirections/test" with the following data:
+      """json
+      {"url": "https://example.org"}
+      """
+    Then the response code should be 200 (OK)
+    Given the client sends the header "If-Match" with ""1174efedab186000-2""
+    When the client does a PATCH request to "/redirections/test" with the following data:
+      """json
+      {"url": "https://example.org"}
+      """
+    Then the response should be a problem with status 422 (Unprocessable Entity)
+    And the problem detail should be "the Idempotency-Key is already used for a different request"
+
+  Scenario: Fail while the request of an idempotency key is in progress
+    Given these "idempotency_key" records exist:
+      | subject   | key    | fingerprint                                                      | created_at           |
+      | api_key:1 | delete | da31d03e9b001bd574152f7522ea69852ed0d87684e99504d12227b89fa3f8f2 | 2009-11-10T23:00:00Z |
+    And the client sends the header "Idempotency-Key" with "delete"
+    When the client does a DELETE request to "/redirections/test"
+    Then the response should be a problem with status 409 (Conflict)
+    And the problem detail should be "a request with this Idempotency-Key is in progress"
+
+  Scenario: Claim an idempotency key again after its lease
+    Given the config "IDEMPOTENCY_KEY_LEASE" is "1m"
+    And these "redirection" records exist:
+      | key  | url                 |
+      | test | https://example.com |
+    And these "idempotency_key" records exist:
+      | subject   | key    | fingerprint                                                      | created_at           |
+      | api_key:1 | delete | da31d03e9b001bd574152f7522ea69852ed0d87684e99504d12227b89fa3f8f2 | 2009-11-10T22:58:00Z |
+    And the client sends the header "Idempotency-Key" with "delete"
+    When the client does a DELETE request to "/redirections/test"
+    Then the response code should be 204 (No Content)
+    And this "idempotency_key" record exists:
+      | subject | api_key:1 |
+      | key     | delete    |
+      | status  | 204       |
+
+  Scenario: Don't store the response of an issued API key
+    Given the client sends the header "Idempotency-Key" with "issue"
+    When the client does a POST request to "/api-keys" with the following data:
+      """json
+      {"name": "deploy", "role": "editor"}
+      """
+    Then the response code should be 201 (Created)
+    And no "idempotency_key" record exists with key "issue"
+
+  Scenario: Idempotency keys are scoped to the caller
+    Given the client sends the header "Idempotency-Key" with "create"
+    When the client does a POST request to "/redirections" with the following data:
+      """json
+      {"key": "one", "url": "https://example.com"}
+      """
+    Then the response code should be 201 (Created)
+    Given the client authenticates as "alice" with role "editor"
+    And the client sends the header "Idempotency-Key" with "create"
+    When the client does a POST request to "/redirections" with the following data:
+      """json
+      {"key": "one", "url": "https://example.com"}
+      """
+    Then the response should be a problem with status 409 (Conflict)
+    And the response header "Idempotent-Replayed" should be not set
+
+  Scenario: Forget idempotency keys after the window
+    Given the config "IDEMPOTENCY_KEY_TTL" is "1h"
+    And the client sends the header "Idempotency-Key" with "create"
+    When the client does a POST request to "/redirections" with the following data:
+      """json
+      {"key": "one", "url": "https://example.com"}
+      """
+    Then the response code should be 201 (Created)
+    Given the current time is "2009-11-11T00:00:00Z"
+    When the client does a POST request to "/redirections" with the following data:
+      """json
+      {"key": "one", "url": "https://example.com"}
+      """
+    Then the response should be a problem with status 409 (Conflict)
+
+  Scenario: Purge idempotency keys older than the window
+    Given the config "IDEMPOTENCY_KEY_TTL" is "1h"
+    And these "idempotency_key" records exist:
+      | subject   | key    | fingerprint | status | created_at           |
+      | api_key:1 | old    | a           | 201    | 2009-11-10T21:00:00Z |
+      | api_key:1 | recent | b           | 201    | 2009-11-10T22:30:00Z |
+    When the sweeper has run
+    Then no "idempotency_key" record exists with key "old"
+    And this "idempotency_key" record exists:
+      | subject | api_key:1 |
+      | key     | recent    |
@@ -0,0 +1,83 @@
+Feature: Redirection keys
+
+  Keys are validated against a policy so they never collide with other routes,
+  and are generated by the server when a client doesn't provide one.
+
+  Scenario: Generate a key when none is given
+    When the client does a POST request to "/redirections" with the following data:
+      """json
+      {
+        "url": "https://example.com/"
+      }
+      """
+    Then the response code should be 201 (Created)
+    And the response JSON field "key" should match "^[23456789abcdefghijkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ]{7}$"
+    And the response JSON field "key" is saved as "key"
+    And the response header "Location" should be "/redirections/{{key}}"
+    When the client does a GET request to "/{{key}}"
+    Then the response code should be 302 (Found)
+    And the response header "Location" should be "https://example.com/"
+
+  Scenario: Generate keys with the configured alphabet and length
+    Given the config "GENERATED_KEY_ALPHABET" is "x"
+    And the config "GENERATED_KEY_LENGTH" is "3"
+    When the client does a POST request to "/redirections" with the following data:
+      """json
+      {
+        "url": "https://example.com/"
+      }
+      """
+    Then the response code should be 201 (Created)
+    And the response JSON field "key" should be "xxx"
+
+  Scenario: Retry generating a key until it's not taken
+    Given the config "GENERATED_KEY_ALPHABET" is "xy"
+    And the config "GENERATED_KEY_LENGTH" is "3"
+    And the follow "redirection" record exist:
+      | key | xxx                 |
+      | url | https://example.com |
+    And the random bytes are "00 00 00 01 01 01"
+    When the client does a POST request to "/redirections" with the following data:
+      """json
+      {
+        "url": "https://example.com/"
+      }
+      """
+    Then the response code should be 201 (Created)
+    And the response JSON field "key" should be "yyy"
+
+  Scenario: Fail when every generated key is taken
+    Given the config "GENERATED_KEY_ALPHABET" is "x"
+    And the config "GENERATED_KEY_LENGTH" is "3"
+    And the follow "redirection" record exist:
+      | key | xxx                 |
+      | url | https://example.com |
+    When the client does a POST request to "/redirections" with the following data:
+      """json
+      {
+        "url": "https://example.com/"
+      }
+      """
+    Then the response should be a problem with status 409 (Conflict)
+
+  Scenario Outline: Reject keys that are not allowed
+    Given the config "KEY_MAX_LENGTH" is "12"
+    And the config "RESERVED_KEYS" is "admin"
+    When the client does a POST request to "/redirections" with the following data:
+      """json
+      {
+        "key": "<key>",
+        "url": "https://example.com/"
+      }
+      """
+    Then the response should be a problem with status 400 (Bad Request)
+    And the problem should have a field error for "key" saying "<message>"
+
+    Examples:
+      | key             | message                           |
+      | health          | is reserved                       |
+      | redirections    | is reserved                       |
+      | Admin           | is reserved                       |
+      | with space      | can not contain ' '               |
+      | some/path       | can not contain '/'               |
+      | much-too-long-1 | must be between 1 and 12 characters |
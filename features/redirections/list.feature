@@ -0,0 +1,100 @@
+Feature: List redirections
+
+  Clients can page through all stored redirections, optionally filtered and
+  sorted.
+
+  Background:
+    Given these "redirection" records exist:
+      | key     | url                         | created_at           | updated_at           |
+      | alpha   | https://example.com/alpha   | 2009-01-01T00:00:00Z | 2009-06-01T00:00:00Z |
+      | bravo   | https://example.org/bravo   | 2009-02-01T00:00:00Z | 2009-02-01T00:00:00Z |
+      | charlie | https://example.com/charlie | 2009-03-01T00:00:00Z | 2009-03-01T00:00:00Z |
+      | alps    | https://example.net/alps    | 2009-04-01T00:00:00Z | 2009-04-01T00:00:00Z |
+
+  Scenario: List all redirections sorted by key
+    When the client does a GET request to "/redirections"
+    Then the response code should be 200 (OK)
+    And the response body should be the following "application/json":
+      """json
+      {
+        "items": [
+          {"namespace": "default", "key": "alpha", "url": "https://example.com/alpha", "created_at": "2009-01-01T00:00:00Z", "updated_at": "2009-06-01T00:00:00Z", "version": 1},
+          {"namespace": "default", "key": "alps", "url": "https://example.net/alps", "created_at": "2009-04-01T00:00:00Z", "updated_at": "2009-04-01T00:00:00Z", "version": 1},
+          {"namespace": "default", "key": "bravo", "url": "https://example.org/bravo", "created_at": "2009-02-01T00:00:00Z", "updated_at": "2009-02-01T00:00:00Z", "version": 1},
+          {"namespace": "default", "key": "charlie", "url": "https://example.com/charlie", "created_at": "2009-03-01T00:00:00Z", "updated_at": "2009-03-01T00:00:00Z", "version": 1}
+        ],
+        "limit": 20,
+        "has_more": false
+      }
+      """
+
+  Scenario: Page through redirections using the next cursor
+    When the client does a GET request to "/redirections?limit=3&sort=-created_at"
+    Then the response code should be 200 (OK)
+    And the response JSON field "items.0.key" should be "alps"
+    And the response JSON field "items.2.key" should be "bravo"
+    And the response JSON field "has_more" should be "true"
+    And the response JSON field "next_cursor" is saved as "cursor"
+    When the client does a GET request to "/redirections?limit=3&sort=-created_at&cursor={{cursor}}"
+    Then the response code should be 200 (OK)
+    And the response JSON field "items.0.key" should be "alpha"
+    And the response JSON field "items.1" should be not set
+    And the response JSON field "has_more" should be "false"
+    And the response JSON field "next_cursor" should be not set
+
+  Scenario: Filter redirections by key prefix
+    When the client does a GET request to "/redirections?key_prefix=al"
+    Then the response code should be 200 (OK)
+    And the response JSON field "items.0.key" should be "alpha"
+    And the response JSON field "items.1.key" should be "alps"
+    And the response JSON field "items.2" should be not set
+
+  Scenario: Filter redirections by url substring
+    When the client does a GET request to "/redirections?url_contains=example.com"
+    Then the response code should be 200 (OK)
+    And the response JSON field "items.0.key" should be "alpha"
+    And the response JSON field "items.1.key" should be "charlie"
+    And the response JSON field "items.2" should be not set
+
+  Scenario: Filter redirections by timestamp ranges
+    When the client does a GET request to "/redirections?created_after=2009-02-01T00:00:00Z&updated_before=2009-04-01T00:00:00Z"
+    Then the response code should be 200 (OK)
+    And the response JSON field "items.0.key" should be "bravo"
+    And the response JSON field "items.1.key" should be "charlie"
+    And the response JSON field "items.2" should be not set
+
+  Scenario: Sort redirections by last update
+    When the client does a GET request to "/redirections?sort=-updated_at&limit=1"
+    Then the response code should be 200 (OK)
+    And the response JSON field "items.0.key" should be "alpha"
+    And the response JSON field "has_more" should be "true"
+
+  Scenario: Fail to list with an invalid limit
+    When the client does a GET request to "/redirections?limit=1000"
+    Then the response should be a problem with status 400 (Bad Request)
+    And the problem detail should be "limit must be a number between 1 and 100"
+
+  Scenario: Fail to list with a cursor of another sort
+    When the client does a GET request to "/redirections?limit=1"
+    And the response JSON field "next_cursor" is saved as "cursor"
+    And the client does a GET request to "/redirections?sort=created_at&cursor={{cursor}}"
+    Then the response should be a problem with status 400 (Bad Request)
+
+  Scenario: Filter and sort by timestamps with a DSN without a time format
+    Given the config "DSN" is ":memory:"
+    When the client does a POST request to "/redirections" with the following data:
+      """json
+      {"key": "first", "url": "https://example.com"}
+      """
+    Given the current time is "2009-11-11T00:00:00Z"
+    When the client does a POST request to "/redirections" with the following data:
+      """json
+      {"key": "second", "url": "https://example.com"}
+      """
+    And the client does a GET request to "/redirections?created_after=2009-11-10T23:30:00Z"
+    Then the response code should be 200 (OK)
+    And the response JSON field "items.0.key" should be "second"
+    And the response JSON field "items.1" should be not set
+    When the client does a GET request to "/redirections?sort=-created_at"
+    Then the response JSON field "items.0.key" should be "second"
+    And the response JSON field "items.1.key" should be "first"
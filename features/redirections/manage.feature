@@ -10,27 +10,61 @@ Feature: Redirections
         "url": "https://www.youtube.com/watch?v=dQw4w9WgXcQ"
       }
       """
-    Then the response code should be 200 (Created)
+    Then the response code should be 201 (Created)
+    And the response header "Location" should be "/redirections/rickroll"
+    And the response body should be the following "application/json":
+      """json
+      {
+        "namespace": "default",
+        "key": "rickroll",
+        "url": "https://www.youtube.com/watch?v=dQw4w9WgXcQ",
+        "owner": "api_key:1",
+        "created_at": "2009-11-10T23:00:00Z",
+        "updated_at": "2009-11-10T23:00:00Z",
+        "version": 1
+      }
+      """
     And this "redirection" record exists:
       | key        | rickroll                                    |
       | url        | https://www.youtube.com/watch?v=dQw4w9WgXcQ |
+      | owner      | api_key:1                                   |
       | created_at | 2009-11-10T23:00:00Z                        |
       | updated_at | 2009-11-10T23:00:00Z                        |
 
-  Scenario: Fail to create redirection without an url
+  Scenario: Fail to create a redirection with an existing key
+    Given the follow "redirection" record exist:
+      | key        | rickroll             |
+      | url        | http://example.com   |
     When the client does a POST request to "/redirections" with the following data:
       """json
       {
-        "key": "test"
+        "key": "rickroll",
+        "url": "https://www.youtube.com/watch?v=dQw4w9WgXcQ"
       }
       """
-    Then the response code should be 400 (Bad Request)
-    And the response body should be the following "application/json":
+    Then the response should be a problem with status 409 (Conflict)
+    And the problem should have a field error for "key" saying "already exists"
+    And this "redirection" record exists:
+      | key        | rickroll             |
+      | url        | http://example.com   |
+
+  Scenario: Fail to create redirection without an url
+    When the client does a POST request to "/redirections" with the following data:
       """json
       {
-        "error": "key and url are required"
+        "key": "test"
       }
       """
+    Then the response should be a problem with status 400 (Bad Request)
+    And the problem detail should be "the redirection is invalid"
+    And the problem should have a field error for "url" saying "is required"
+
+  Scenario: Fail to create redirection with invalid JSON
+    When the client does a POST request to "/redirections" with the following data:
+      """json
+      {"key":
+      """
+    Then the response should be a problem with status 400 (Bad Request)
 
   Scenario: Delete a redirection by key
     Given the follow "redirection" record exist:
@@ -40,5 +74,116 @@ Feature: Redirections
       | updated_at | 2009-11-10T23:00:00Z |
     When the client does a DELETE request to "/redirections/test"
     Then the response code should be 204 (No Content)
-    And no "redirection" record exists with id "test"
+    And this "redirection" record exists:
+      | key        | test                 |
+      | deleted_at | 2009-11-10T23:00:00Z |
+
+
+  Scenario: Get a redirection by key
+    Given the follow "redirection" record exist:
+      | key        | test                 |
+      | url        | http://example.com   |
+      | created_at | 2009-01-01T00:00:00Z |
+      | updated_at | 2009-01-01T00:00:00Z |
+    When the client does a GET request to "/redirections/test"
+    Then the response code should be 200 (OK)
+    And the response body should be the following "application/json":
+      """json
+      {
+        "namespace": "default",
+        "key": "test",
+        "url": "http://example.com",
+        "created_at": "2009-01-01T00:00:00Z",
+        "updated_at": "2009-01-01T00:00:00Z",
+        "version": 1
+      }
+      """
+
+  Scenario: Fail to get a non-existing redirection
+    When the client does a GET request to "/redirections/does-not-exist"
+    Then the response should be a problem with status 404 (Not Found)
+    And the problem detail should be "redirection not found"
+
+  Scenario: Replace a redirection
+    Given the follow "redirection" record exist:
+      | key        | test                 |
+      | url        | http://example.com   |
+      | created_at | 2009-01-01T00:00:00Z |
+      | updated_at | 2009-01-01T00:00:00Z |
+    When the client does a PUT request to "/redirections/test" with the following data:
+      """json
+      {
+        "url": "http://example.org"
+      }
+      """
+    Then the response code should be 200 (OK)
+    And the response body should be the following "application/json":
+      """json
+      {
+        "namespace": "default",
+        "key": "test",
+        "url": "http://example.org",
+        "created_at": "2009-01-01T00:00:00Z",
+        "updated_at": "2009-11-10T23:00:00Z",
+        "version": 2
+      }
+      """
+    And this "redirection" record exists:
+      | key        | test                 |
+      | url        | http://example.org   |
+      | created_at | 2009-01-01T00:00:00Z |
+      | updated_at | 2009-11-10T23:00:00Z |
 
+  Scenario: Fail to replace a redirection without an url
+    Given the follow "redirection" record exist:
+      | key        | test                 |
+      | url        | http://example.com   |
+    When the client does a PUT request to "/redirections/test" with the following data:
+      """json
+      {}
+      """
+    Then the response should be a problem with status 400 (Bad Request)
+    And the problem should have a field error for "url" saying "is required"
+
+  Scenario: Fail to replace a non-existing redirection
+    When the client does a PUT request to "/redirections/does-not-exist" with the following data:
+      """json
+      {
+        "url": "http://example.org"
+      }
+      """
+    Then the response should be a problem with status 404 (Not Found)
+
+  Scenario: Partially update a redirection
+    Given the follow "redirection" record exist:
+      | key        | test                 |
+      | url        | http://example.com   |
+      | created_at | 2009-01-01T00:00:00Z |
+      | updated_at | 2009-01-01T00:00:00Z |
+    When the client does a PATCH request to "/redirections/test" with the following data:
+      """json
+      {
+        "url": "http://example.org"
+      }
+      """
+    Then the response code should be 200 (OK)
+    And this "redirection" record exists:
+      | key        | test                 |
+      | url        | http://example.org   |
+      | created_at | 2009-01-01T00:00:00Z |
+      | updated_at | 2009-11-10T23:00:00Z |
+
+  Scenario: Partially update a redirection without changes
+    Given the follow "redirection" record exist:
+      | key        | test                 |
+      | url        | http://example.com   |
+      | created_at | 2009-01-01T00:00:00Z |
+      | updated_at | 2009-01-01T00:00:00Z |
+    When the client does a PATCH request to "/redirections/test" with the following data:
+      """json
+      {}
+      """
+    Then the response code should be 200 (OK)
+    And this "redirection" record exists:
+      | key        | test                 |
+      | url        | http://example.com   |
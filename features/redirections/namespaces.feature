@@ -0,0 +1,179 @@
+Feature: Namespaces
+
+  Teams keep their redirections in their own namespace, selected with the
+  X-Namespace header. Without the header the default namespace is used.
+  Callers other than admins can only use the namespaces of their API key or
+  JWT, or the default namespace when they have none.
+
+  Scenario: The same key in different namespaces
+    Given the client sends the header "X-Namespace" with "team-a"
+    And the client does a POST request to "/redirections" with the following data:
+      """json
+      { "key": "promo", "url": "https://a.example.com/" }
+      """
+    And the response code should be 201 (Created)
+    And the response JSON field "namespace" should be "team-a"
+    And the client stops sending the header "X-Namespace"
+    When the client does a POST request to "/redirections" with the following data:
+      """json
+      { "key": "promo", "url": "https://example.com/" }
+      """
+    Then the response code should be 201 (Created)
+    And the response JSON field "namespace" should be "default"
+    And this "redirection" record exists:
+      | namespace | team-a                 |
+      | key       | promo                  |
+      | url       | https://a.example.com/ |
+    When the client does a GET request to "/redirections/promo" with the following headers:
+      | X-Namespace | team-a |
+    Then the response code should be 200 (OK)
+    And the response JSON field "url" should be "https://a.example.com/"
+
+  Scenario: Endpoints only see the redirections of their namespace
+    Given these "redirection" records exist:
+      | namespace | key   | url                    |
+      | team-a    | promo | https://a.example.com/ |
+      | team-a    | sale  | https://a.example.com/ |
+      | team-b    | promo | https://b.example.com/ |
+    And the client sends the header "X-Namespace" with "team-b"
+    When the client does a GET request to "/redirections"
+    Then the response JSON field "items.0.key" should be "promo"
+    And the response JSON field "items.1" should be not set
+    When the client does a GET request to "/redirections/sale"
+    Then the response should be a problem with status 404 (Not Found)
+    When the client does a DELETE request to "/redirections/promo"
+    Then the response code should be 204 (No Content)
+    And this "redirection" record exists:
+      | namespace | team-a                 |
+      | key       | promo                  |
+      | url       | https://a.example.com/ |
+
+  Scenario: Only use the namespaces of the API key
+    Given the client authenticates as "alice" with role "editor" in namespaces "team-a,team-c"
+    And the client sends the header "X-Namespace" with "team-a"
+    When the client does a POST request to "/redirections" with the following data:
+      """json
+      { "key": "promo", "url": "https://a.example.com/" }
+      """
+    Then the response code should be 201 (Created)
+    Given the client sends the header "X-Namespace" with "team-b"
+    When the client does a POST request to "/redirections" with the following data:
+      """json
+      { "key": "promo", "url": "https://b.example.com/" }
+      """
+    Then the response should be a problem with status 403 (Forbidden)
+    And the problem detail should be "the namespace team-b is not allowed"
+    When the client does a GET request to "/namespaces/team-b"
+    Then the response should be a problem with status 403 (Forbidden)
+    When the client stops sending the header "X-Namespace"
+    And the client does a GET request to "/redirections"
+    Then the response should be a problem with status 403 (Forbidden)
+    And the problem detail should be "the namespace default is not allowed"
+
+  Scenario: Callers without namespaces only use the default namespace
+    Given the client authenticates as "alice" with role "editor"
+    When the client does a POST request to "/redirections" with the following data:
+      """json
+      { "key": "promo", "url": "https://example.com/" }
+      """
+    Then the response code should be 201 (Created)
+    When the client does a GET request to "/redirections" with the following headers:
+      | X-Namespace | team-a |
+    Then the response should be a problem with status 403 (Forbidden)
+
+  Scenario: Use the namespaces of a JWT
+    Given the client sends a JWT signed with the "rsa" key with the following claims:
+      """json
+      {"sub": "bob", "roles": "editor", "namespaces": ["team-a"], "exp": 1257897600}
+      """
+    When the client does a GET request to "/redirections" with the following headers:
+      | X-Namespace | team-a |
+    Then the response code should be 200 (OK)
+    When the client does a GET request to "/redirections" with the following headers:
+      | X-Namespace | team-b |
+    Then the response should be a problem with status 403 (Forbidden)
+
+  Scenario: Reject an invalid namespace
+    When the client does a GET request to "/redirections" with the following headers:
+      | X-Namespace | Team A |
+    Then the response should be a problem with status 400 (Bad Request)
+    And the problem detail should be "invalid X-Namespace header"
+
+  Scenario: Redirect within a namespace by path prefix
+    Given these "redirection" records exist:
+      | namespace | key   | url                    |
+      | team-a    | promo | https://a.example.com/ |
+      | default   | promo | https://example.com/   |
+    When the client does a GET request to "/~team-a/promo"
+    Then the response code should be 302 (Found)
+    And the response header "Location" should be "https://a.example.com/"
+    When the client does a GET request to "/promo"
+    Then the response header "Location" should be "https://example.com/"
+    When the client does a GET request to "/~team-b/promo"
+    Then the response should be a problem with status 404 (Not Found)
+
+  Scenario: Redirect within a namespace by subdomain
+    Given the config "NAMESPACE_DOMAIN" is "short.example"
+    And these "redirection" records exist:
+      | namespace | key   | url                    |
+      | team-a    | promo | https://a.example.com/ |
+      | default   | promo | https://example.com/   |
+    When the client does a GET request to "/promo" with the following headers:
+      | Host | team-a.short.example:8080 |
+    Then the response header "Location" should be "https://a.example.com/"
+    When the client does a GET request to "/promo" with the following headers:
+      | Host | short.example |
+    Then the response header "Location" should be "https://example.com/"
+
+  Scenario: Count hits per namespace
+    Given these "redirection" records exist:
+      | namespace | key   | url                    |
+      | team-a    | promo | https://a.example.com/ |
+      | default   | promo | https://example.com/   |
+    When the client does a GET request to "/~team-a/promo"
+    And the client does a GET request to "/~team-a/promo"
+    And the client does a GET request to "/promo"
+    And all pending hits are flushed
+    And the client does a GET request to "/redirections/promo/stats" with the following headers:
+      | X-Namespace | team-a |
+    Then the response JSON field "total" should be "2"
+
+  Scenario: Refuse redirections beyond the quota of a namespace
+    Given the client does a PUT request to "/namespaces/team-a" with the following data:
+      """json
+      { "max_redirections": 1 }
+      """
+    And the client sends the header "X-Namespace" with "team-a"
+    And the client does a POST request to "/redirections" with the following data:
+      """json
+      { "key": "promo", "url": "https://a.example.com/" }
+      """
+    When the client does a POST request to "/redirections" with the following data:
+      """json
+      { "key": "sale", "url": "https://a.example.com/" }
+      """
+    Then the response should be a problem with status 403 (Forbidden)
+    And the problem detail should be "the quota of namespace team-a is exceeded"
+    When the client does a GET request to "/namespaces/team-a"
+    Then the response code should be 200 (OK)
+    And the response JSON field "max_redirections" should be "1"
+    And the response JSON field "redirections" should be "1"
+
+  Scenario: Apply the configured quota to namespaces without their own
+    Given the config "NAMESPACE_QUOTA" is "1"
+    And these "redirection" records exist:
+      | namespace | key   | url                    |
+      | team-a    | promo | https://a.example.com/ |
+    When the client does a POST request to "/redirections" with the following data:
+      """json
+      { "key": "sale", "url": "https://example.com/" }
+      """
+    Then the response code should be 201 (Created)
+    Given the client sends the header "X-Namespace" with "team-a"
+    When the client does a POST request to "/redirections" with the following data:
+      """json
+      { "key": "sale", "url": "https://a.example.com/" }
+      """
+    Then the response should be a problem with status 403 (Forbidden)
+    When the client does a GET request to "/namespaces/team-a"
+    Then the response JSON field "max_redirections" should be "<nil>"
@@ -0,0 +1,144 @@
+Feature: Permissions
+
+  Viewers can read, editors can also create and change redirections and admins
+  can do everything. Redirections are owned by whoever created them, only the
+  owner and admins can view, change or delete them. Owners of API keys are the
+  id of the key: the test key is 1, the first key a scenario authenticates with
+  is 2.
+
+  Background:
+    Given these "redirection" records exist:
+      | key    | url                 | owner     |
+      | mine   | https://example.com | api_key:2 |
+      | theirs | https://example.com | api_key:3 |
+
+  Scenario Outline: A <role> doing <method> <path> gets <status>
+    Given the client authenticates as "alice" with role "<role>"
+    When the client does a <method> request to "<path>" with the following data:
+      """json
+      {"url": "https://example.org"}
+      """
+    Then the response code should be <status> (<reason>)
+
+    Examples: viewers
+      | role   | method | path                     | status | reason    |
+      | viewer | GET    | /redirections            | 200    | OK        |
+      | viewer | GET    | /redirections/mine       | 200    | OK        |
+      | viewer | GET    | /redirections/mine/stats | 200    | OK        |
+      | viewer | GET    | /redirections/theirs     | 403    | Forbidden |
+      | viewer | POST   | /redirections            | 403    | Forbidden |
+      | viewer | PATCH  | /redirections/mine       | 403    | Forbidden |
+      | viewer | DELETE | /redirections/mine       | 403    | Forbidden |
+      | viewer | GET    | /domains                 | 200    | OK        |
+      | viewer | POST   | /rules                   | 403    | Forbidden |
+      | viewer | GET    | /api-keys                | 403    | Forbidden |
+
+    Examples: editors
+      | role   | method | path                       | status | reason     |
+      | editor | POST   | /redirections              | 201    | Created    |
+      | editor | PUT    | /redirections/mine         | 200    | OK         |
+      | editor | PATCH  | /redirections/mine         | 200    | OK         |
+      | editor | DELETE | /redirections/mine         | 204    | No Content |
+      | editor | GET    | /redirections/theirs       | 403    | Forbidden  |
+      | editor | GET    | /redirections/theirs/stats | 403    | Forbidden  |
+      | editor | PUT    | /redirections/theirs       | 403    | Forbidden  |
+      | editor | PATCH  | /redirections/theirs       | 403    | Forbidden  |
+      | editor | DELETE | /redirections/theirs       | 403    | Forbidden  |
+      | editor | PUT    | /namespaces/default        | 403    | Forbidden  |
+      | editor | GET    | /api-keys                  | 403    | Forbidden  |
+
+    Examples: admins
+      | role  | method | path                 | status | reason     |
+      | admin | GET    | /redirections/theirs | 200    | OK         |
+      | admin | PATCH  | /redirections/theirs | 200    | OK         |
+      | admin | DELETE | /redirections/theirs | 204    | No Content |
+      | admin | PUT    | /namespaces/default  | 200    | OK         |
+      | admin | GET    | /api-keys            | 200    | OK         |
+
+  Scenario: The owner is the creator of a redirection
+    Given the client authenticates as "alice" with role "editor"
+    When the client does a POST request to "/redirections" with the following data:
+      """json
+      {"key": "new", "url": "https://example.org"}
+      """
+    Then the response code should be 201 (Created)
+    And the response JSON field "owner" should be "api_key:2"
+    And this "redirection" record exists:
+      | key   | new       |
+      | owner | api_key:2 |
+
+  Scenario: Fail to change the redirection of someone else
+    Given the client authenticates as "alice" with role "editor"
+    When the client does a DELETE request to "/redirections/theirs"
+    Then the response should be a problem with status 403 (Forbidden)
+    And the problem detail should be "the redirection is owned by someone else"
+    And this "redirection" record exists:
+      | key | theirs |
+
+  Scenario: Fail to manage without the required role
+    Given the client authenticates as "alice" with role "viewer"
+    When the client does a POST request to "/redirections" with the following data:
+      """json
+      {"url": "https://example.org"}
+      """
+    Then the response should be a problem with status 403 (Forbidden)
+    And the problem detail should be "this requires the editor role"
+
+  Scenario: List only owned redirections
+    Given the client authenticates as "alice" with role "viewer"
+    When the client does a GET request to "/redirections"
+    Then the response code should be 200 (OK)
+    And the response JSON field "items.0.key" should be "mine"
+    And the response JSON field "items.1" should be not set
+
+  Scenario: Admins list all redirections
+    When the client does a GET request to "/redirections"
+    Then the response code should be 200 (OK)
+    And the response JSON field "items.0.key" should be "mine"
+    And the response JSON field "items.1.key" should be "theirs"
+
+  Scenario: Use the roles of a JWT
+    Given these "redirection" records exist:
+      | key   | url                 | owner    |
+      | token | https://example.com | jwt::bob |
+    And the client sends a JWT signed with the "rsa" key with the following claims:
+      """json
+      {"sub": "bob", "roles": "editor", "exp": 1257897600}
+      """
+    When the client does a PATCH request to "/redirections/token" with the following data:
+      """json
+      {"url": "https://example.org"}
+      """
+    Then the response code should be 200 (OK)
+    When the client does a PATCH request to "/redirections/mine" with the following data:
+      """json
+      {"url": "https://example.org"}
+      """
+    Then the response should be a problem with status 403 (Forbidden)
+
+  Scenario: An API key and a JWT with the same name are different owners
+    Given the client authenticates as "bob" with role "editor"
+    When the client does a POST request to "/redirections" with the following data:
+      """json
+      {"key": "bobs", "url": "https://example.com"}
+      """
+    Then the response code should be 201 (Created)
+    Given the client sends a JWT signed with the "rsa" key with the following claims:
+      """json
+      {"sub": "bob", "roles": "editor", "exp": 1257897600}
+      """
+    When the client does a PATCH request to "/redirections/bobs" with the following data:
+      """json
+      {"url": "https://example.org"}
+      """
+    Then the response should be a problem with status 403 (Forbidden)
+    And the problem detail should be "the redirection is owned by someone else"
+
+  Scenario: A new key with the name of a revoked one doesn't own its redirections
+    Given these "api_key" records exist:
+      | id | name  | prefix    | role   | hash    | created_at           | revoked_at           |
+      | 2  | alice | rk_000000 | editor | revoked | 2009-11-01T00:00:00Z | 2009-11-02T00:00:00Z |
+    And the client authenticates as "alice" with role "editor"
+    When the client does a GET request to "/redirections/mine"
+    Then the response should be a problem with status 403 (Forbidden)
+    And the problem detail should be "the redirection is owned by someone else"
@@ -10,11 +10,60 @@ Feature: Redirect http GET requests to the appropriate URL
     When the client does a GET request to "/test"
     Then the response code should be 302 (Found)
     And the response header "Location" should be "http://example.com"
-    And the response body should be the following "text/html":
+    And the response header "Vary" should be "Accept"
+    And the response body should be the following "text/html; charset=utf-8":
       """html
-      <script type=\"text/javascript\">window.location = "http://example.com";</script>
+      <!DOCTYPE html>
+      <html>
+      <head>
+      <meta charset="utf-8">
+      <meta http-equiv="refresh" content="0; url=http://example.com">
+      <title>Redirecting</title>
+      </head>
+      <body>
+      <p>Redirecting to <a href="http://example.com">http://example.com</a>.</p>
+      <script>window.location.replace("http://example.com");</script>
+      </body>
+      </html>
+      """
+
+  Scenario: Escape the stored url in the redirect body
+    Given the follow "redirection" record exist:
+      | key        | test                                          |
+      | url        | http://example.com/?a=1&b="</script><script> |
+    When the client does a GET request to "/test"
+    Then the response code should be 302 (Found)
+    And the response body should be the following "text/html; charset=utf-8":
+      """html
+      <!DOCTYPE html>
+      <html>
+      <head>
+      <meta charset="utf-8">
+      <meta http-equiv="refresh" content="0; url=http://example.com/?a=1&amp;b=&#34;&lt;/script&gt;&lt;script&gt;">
+      <title>Redirecting</title>
+      </head>
+      <body>
+      <p>Redirecting to <a href="http://example.com/?a=1&amp;b=%22%3c/script%3e%3cscript%3e">http://example.com/?a=1&amp;b=&#34;&lt;/script&gt;&lt;script&gt;</a>.</p>
+      <script>window.location.replace("http://example.com/?a=1\u0026b=\"\u003c/script\u003e\u003cscript\u003e");</script>
+      </body>
+      </html>
+      """
+
+  Scenario: Respond with JSON to clients that prefer it
+    Given the follow "redirection" record exist:
+      | key        | test                 |
+      | url        | http://example.com   |
+    When the client does a GET request to "/test" with the following headers:
+      | Accept | text/html;q=0.5, application/json |
+    Then the response code should be 302 (Found)
+    And the response header "Location" should be "http://example.com"
+    And the response body should be the following "application/json":
+      """json
+      {
+        "url": "http://example.com"
+      }
       """
 
   Scenario: Fail to redirect to a non-existing key
     When the client does a GET request to "/does-not-exists"
-    Then the response code should be 404 (Not Found)
+    Then the response should be a problem with status 404 (Not Found)
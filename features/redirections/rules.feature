@@ -0,0 +1,166 @@
+Feature: Redirect paths with pattern rules
+
+  Paths that don't match a redirection key are redirected by rules, these match
+  by prefix, glob or regular expression and substitute the captures into their
+  target.
+
+  Scenario: Create a prefix rule
+    When the client does a POST request to "/rules" with the following data:
+      """json
+      {
+        "kind": "prefix",
+        "pattern": "docs/",
+        "target": "https://docs.example.com/$1"
+      }
+      """
+    Then the response code should be 201 (Created)
+    And the response header "Location" should be "/rules/1"
+    And the response JSON field "priority" should be "0"
+    And this "rule" record exists:
+      | id      | 1                           |
+      | kind    | prefix                      |
+      | pattern | docs/                       |
+      | target  | https://docs.example.com/$1 |
+    When the client does a GET request to "/docs/guides/intro"
+    Then the response code should be 302 (Found)
+    And the response header "Location" should be "https://docs.example.com/guides/intro"
+
+  Scenario: Redirect with a glob rule
+    Given the client does a POST request to "/rules" with the following data:
+      """json
+      {
+        "kind": "glob",
+        "pattern": "blog/*/*",
+        "target": "https://example.com/posts/$2?year=$1"
+      }
+      """
+    When the client does a GET request to "/blog/2009/hello"
+    Then the response code should be 302 (Found)
+    And the response header "Location" should be "https://example.com/posts/hello?year=2009"
+
+  Scenario: Redirect with a regex rule and named captures
+    Given the client does a POST request to "/rules" with the following data:
+      """json
+      {
+        "kind": "regex",
+        "pattern": "u/(?P<user>[a-z]+)",
+        "target": "https://example.com/users/${user}",
+        "status": 301
+      }
+      """
+    When the client does a GET request to "/u/gopher"
+    Then the response code should be 301 (Moved Permanently)
+    And the response header "Location" should be "https://example.com/users/gopher"
+    When the client does a GET request to "/u/Gopher"
+    Then the response should be a problem with status 404 (Not Found)
+
+  Scenario: Redirections take precedence over rules
+    Given the follow "redirection" record exist:
+      | key | docs                     |
+      | url | https://example.com/docs |
+    And the client does a POST request to "/rules" with the following data:
+      """json
+      { "kind": "prefix", "pattern": "do", "target": "https://example.com/rule/$1" }
+      """
+    When the client does a GET request to "/docs"
+    Then the response header "Location" should be "https://example.com/docs"
+    When the client does a GET request to "/docs/intro"
+    Then the response header "Location" should be "https://example.com/rule/cs/intro"
+
+  Scenario: Rules are matched by priority, then the longest pattern
+    Given the client does a POST request to "/rules" with the following data:
+      """json
+      { "kind": "prefix", "pattern": "d", "target": "https://example.com/short" }
+      """
+    And the client does a POST request to "/rules" with the following data:
+      """json
+      { "kind": "prefix", "pattern": "docs/", "target": "https://example.com/long" }
+      """
+    And the client does a POST request to "/rules" with the following data:
+      """json
+      { "kind": "glob", "pattern": "*", "target": "https://example.com/fallback", "priority": -1 }
+      """
+    When the client does a GET request to "/docs/intro"
+    Then the response header "Location" should be "https://example.com/long"
+    When the client does a GET request to "/dogs"
+    Then the response header "Location" should be "https://example.com/short"
+    When the client does a GET request to "/cats"
+    Then the response header "Location" should be "https://example.com/fallback"
+    When the client does a GET request to "/rules"
+    Then the response code should be 200 (OK)
+    And the response JSON field "items.0.pattern" should be "docs/"
+    And the response JSON field "items.1.pattern" should be "d"
+    And the response JSON field "items.2.pattern" should be "*"
+
+  Scenario: Replace a rule
+    Given the client does a POST request to "/rules" with the following data:
+      """json
+      { "kind": "prefix", "pattern": "docs/", "target": "https://example.com/old/$1" }
+      """
+    And the response JSON field "id" is saved as "id"
+    When the client does a PUT request to "/rules/{{id}}" with the following data:
+      """json
+      { "kind": "prefix", "pattern": "docs/", "target": "https://example.com/new/$1", "priority": 5 }
+      """
+    Then the response code should be 200 (OK)
+    And the response JSON field "priority" should be "5"
+    When the client does a GET request to "/docs/intro"
+    Then the response header "Location" should be "https://example.com/new/intro"
+
+  Scenario: Delete a rule
+    Given the client does a POST request to "/rules" with the following data:
+      """json
+      { "kind": "prefix", "pattern": "docs/", "target": "https://example.com/$1" }
+      """
+    And the response JSON field "id" is saved as "id"
+    When the client does a DELETE request to "/rules/{{id}}"
+    Then the response code should be 204 (No Content)
+    And no "rule" record exists with id "1"
+    When the client does a GET request to "/docs/intro"
+    Then the response should be a problem with status 404 (Not Found)
+
+  Scenario: Fail to get a non-existing rule
+    When the client does a GET request to "/rules/404"
+    Then the response should be a problem with status 404 (Not Found)
+    And the problem detail should be "rule not found"
+
+  Scenario: Fail to create a rule with an existing pattern
+    Given the client does a POST request to "/rules" with the following data:
+      """json
+      { "kind": "prefix", "pattern": "docs/", "target": "https://example.com/$1" }
+      """
+    When the client does a POST request to "/rules" with the following data:
+      """json
+      { "kind": "prefix", "pattern": "docs/", "target": "https://example.org/$1" }
+      """
+    Then the response should be a problem with status 409 (Conflict)
+    And the problem should have a field error for "pattern" saying "already exists"
+
+  Scenario: Don't redirect to a disallowed host through a capture
+    Given the config "DENIED_HOSTS" is "evil.example"
+    And the client does a POST request to "/rules" with the following data:
+      """json
+      { "kind": "regex", "pattern": "go/([a-z.]+)", "target": "https://$1/" }
+      """
+    When the client does a GET request to "/go/good.example"
+    Then the response header "Location" should be "https://good.example/"
+    When the client does a GET request to "/go/evil.example"
+    Then the response should be a problem with status 404 (Not Found)
+
+  Scenario Outline: Reject invalid rules
+    When the client does a POST request to "/rules" with the following data:
+      """json
+      { "kind": "<kind>", "pattern": "<pattern>", "target": "<target>" }
+      """
+    Then the response should be a problem with status 400 (Bad Request)
+    And the problem should have a field error for "<field>" saying "<message>"
+
+    Examples:
+      | kind   | pattern | target                    | field   | message                                   |
+      | wild   | docs/   | https://example.com/$1    | kind    | must be one of prefix, glob, regex        |
+      | prefix |         | https://example.com/$1    | pattern | is required                               |
+      | regex  | docs/(  | https://example.com/$1    | pattern | is not a valid regular expression         |
+      | prefix | docs/   |                           | target  | is required                               |
+      | prefix | docs/   | https://example.com/$2    | target  | refers to $2 but the pattern has 1 captures |
+      | glob   | docs/*  | https://example.com/${id} | target  | refers to unknown capture id              |
+      | prefix | docs/   | ftp://example.com/$1      | target  | scheme ftp is not allowed                 |
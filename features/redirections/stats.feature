@@ -0,0 +1,135 @@
+Feature: Redirection statistics
+
+  Every time a redirection is resolved a hit is recorded, clients can request
+  the totals and time-bucketed counts of those hits.
+
+  Scenario: Record a hit when redirecting
+    Given the follow "redirection" record exist:
+      | key | test               |
+      | url | http://example.com |
+    And the client's remote address is "203.0.113.42"
+    When the client does a GET request to "/test" with the following headers:
+      | Referer    | https://news.example.org/ |
+      | User-Agent | Example/1.0               |
+    Then the response code should be 302 (Found)
+    When all pending hits are flushed
+    And this "hit" record exists:
+      | id         | 1                         |
+      | key        | test                      |
+      | created_at | 2009-11-10T23:00:00Z      |
+      | referrer   | https://news.example.org/ |
+      | user_agent | Example/1.0               |
+      | ip         | 203.0.113.0               |
+
+  Scenario: Anonymize IPv6 addresses
+    Given the follow "redirection" record exist:
+      | key | test               |
+      | url | http://example.com |
+    And the client's remote address is "2001:db8:85a3:8d3:1319:8a2e:370:7348"
+    When the client does a GET request to "/test"
+    Then the response code should be 302 (Found)
+    When all pending hits are flushed
+    Then this "hit" record exists:
+      | id | 1             |
+      | ip | 2001:db8:85a3:: |
+
+  Scenario: Report the hit recorder counters as metrics
+    Given the follow "redirection" record exist:
+      | key | test               |
+      | url | http://example.com |
+    When the client does a GET request to "/test"
+    And the client does a GET request to "/test"
+    And all pending hits are flushed
+    And the client does a GET request to "/metrics"
+    Then the response code should be 200 (OK)
+    And the response JSON field "hits.recorded" should be "2"
+    And the response JSON field "hits.flushed" should be "2"
+    And the response JSON field "hits.dropped" should be "0"
+    And the response JSON field "hits.pending" should be "0"
+
+  Scenario: Drop hits when the buffer is full
+    Given the config "HIT_BUFFER_SIZE" is "0"
+    And the follow "redirection" record exist:
+      | key | test               |
+      | url | http://example.com |
+    When the client does a GET request to "/test"
+    Then the response code should be 302 (Found)
+    When the client does a GET request to "/metrics"
+    Then the response JSON field "hits.dropped" should be "1"
+
+  Scenario: Get daily statistics of the last week
+    Given the follow "redirection" record exist:
+      | key | test               |
+      | url | http://example.com |
+    And these "hit" records exist:
+      | key   | created_at           |
+      | test  | 2009-11-01T12:00:00Z |
+      | test  | 2009-11-08T10:00:00Z |
+      | test  | 2009-11-08T11:00:00Z |
+      | test  | 2009-11-10T22:00:00Z |
+      | other | 2009-11-10T22:00:00Z |
+    When the client does a GET request to "/redirections/test/stats"
+    Then the response code should be 200 (OK)
+    And the response body should be the following "application/json":
+      """json
+      {
+        "key": "test",
+        "total": 4,
+        "from": "2009-11-03T23:00:00Z",
+        "to": "2009-11-10T23:00:00Z",
+        "bucket": "day",
+        "count": 3,
+        "buckets": [
+          {"start": "2009-11-03T00:00:00Z", "count": 0},
+          {"start": "2009-11-04T00:00:00Z", "count": 0},
+          {"start": "2009-11-05T00:00:00Z", "count": 0},
+          {"start": "2009-11-06T00:00:00Z", "count": 0},
+          {"start": "2009-11-07T00:00:00Z", "count": 0},
+          {"start": "2009-11-08T00:00:00Z", "count": 2},
+          {"start": "2009-11-09T00:00:00Z", "count": 0},
+          {"start": "2009-11-10T00:00:00Z", "count": 1}
+        ]
+      }
+      """
+
+  Scenario: Get hourly statistics of a given range
+    Given the follow "redirection" record exist:
+      | key | test               |
+      | url | http://example.com |
+    And these "hit" records exist:
+      | key  | created_at           |
+      | test | 2009-11-08T10:00:00Z |
+      | test | 2009-11-08T10:59:59Z |
+      | test | 2009-11-08T12:30:00Z |
+    When the client does a GET request to "/redirections/test/stats?bucket=hour&from=2009-11-08T10:00:00Z&to=2009-11-08T13:00:00Z"
+    Then the response code should be 200 (OK)
+    And the response JSON field "count" should be "3"
+    And the response JSON field "buckets.0.count" should be "2"
+    And the response JSON field "buckets.1.count" should be "0"
+    And the response JSON field "buckets.2.start" should be "2009-11-08T12:00:00Z"
+    And the response JSON field "buckets.2.count" should be "1"
+    And the response JSON field "buckets.3" should be not set
+
+  Scenario: Count recorded hits with a DSN without a time format
+    Given the config "DSN" is ":memory:"
+    And the follow "redirection" record exist:
+      | key | test               |
+      | url | http://example.com |
+    When the client does a GET request to "/test"
+    And all pending hits are flushed
+    And the client does a GET request to "/redirections/test/stats?bucket=hour&from=2009-11-10T23:00:00Z&to=2009-11-11T00:00:00Z"
+    Then the response code should be 200 (OK)
+    And the response JSON field "count" should be "1"
+    And the response JSON field "buckets.0.count" should be "1"
+
+  Scenario: Fail to get statistics of a non-existing redirection
+    When the client does a GET request to "/redirections/does-not-exist/stats"
+    Then the response should be a problem with status 404 (Not Found)
+
+  Scenario: Fail to get statistics with an invalid bucket
+    Given the follow "redirection" record exist:
+      | key | test               |
+      | url | http://example.com |
+    When the client does a GET request to "/redirections/test/stats?bucket=week"
+    Then the response should be a problem with status 400 (Bad Request)
+    And the problem detail should be "bucket must be hour or day"
@@ -0,0 +1,97 @@
+Feature: Redirect status codes
+
+  Each redirection can choose the status code it redirects with, e.g. 301 for
+  permanent moves or 307 to preserve the request method. Without a status the
+  configured default is used.
+
+  Scenario: Redirect with the default status
+    Given the follow "redirection" record exist:
+      | key | test               |
+      | url | http://example.com |
+    When the client does a GET request to "/test"
+    Then the response code should be 302 (Found)
+
+  Scenario: Redirect with the configured default status
+    Given the config "DEFAULT_REDIRECT_STATUS" is "307"
+    And the follow "redirection" record exist:
+      | key | test               |
+      | url | http://example.com |
+    When the client does a GET request to "/test"
+    Then the response code should be 307 (Temporary Redirect)
+    And the response header "Location" should be "http://example.com"
+
+  Scenario: Create a permanent redirection
+    When the client does a POST request to "/redirections" with the following data:
+      """json
+      {
+        "key": "moved",
+        "url": "https://example.com/new",
+        "status": 301
+      }
+      """
+    Then the response code should be 201 (Created)
+    And the response JSON field "status" should be "301"
+    And this "redirection" record exists:
+      | key    | moved |
+      | status | 301   |
+    When the client does a GET request to "/moved"
+    Then the response code should be 301 (Moved Permanently)
+    And the response header "Location" should be "https://example.com/new"
+
+  Scenario: Change the status of a redirection
+    Given the follow "redirection" record exist:
+      | key    | test               |
+      | url    | http://example.com |
+      | status | 301                |
+    When the client does a PATCH request to "/redirections/test" with the following data:
+      """json
+      { "status": 308 }
+      """
+    Then the response code should be 200 (OK)
+    And the response JSON field "status" should be "308"
+    When the client does a GET request to "/test"
+    Then the response code should be 308 (Permanent Redirect)
+
+  Scenario: Restore the default status of a redirection
+    Given the follow "redirection" record exist:
+      | key    | test               |
+      | url    | http://example.com |
+      | status | 301                |
+    When the client does a PATCH request to "/redirections/test" with the following data:
+      """json
+      { "status": null }
+      """
+    Then the response code should be 200 (OK)
+    And the response JSON field "status" should be not set
+    When the client does a GET request to "/test"
+    Then the response code should be 302 (Found)
+
+  Scenario: Replacing a redirection without a status restores the default
+    Given the follow "redirection" record exist:
+      | key    | test               |
+      | url    | http://example.com |
+      | status | 301                |
+    When the client does a PUT request to "/redirections/test" with the following data:
+      """json
+      { "url": "http://example.com" }
+      """
+    Then the response code should be 200 (OK)
+    And the response JSON field "status" should be not set
+
+  Scenario Outline: Reject unsupported statuses
+    When the client does a POST request to "/redirections" with the following data:
+      """json
+      {
+        "key": "test",
+        "url": "https://example.com/",
+        "status": <status>
+      }
+      """
+    Then the response should be a problem with status 400 (Bad Request)
+    And the problem should have a field error for "status" saying "must be one of 301, 302, 303, 307 or 308"
+
+    Examples:
+      | status |
+      | 200    |
+      | 304    |
+      | 404    |
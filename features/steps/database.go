@@ -36,7 +36,7 @@ func (s *DatabaseSteps) InitializeScenario(scenario *godog.ScenarioContext) erro
 	scenario.Step(`^(?:these|this) "([^"]*)" records exist:$`, s.GivenTheseRecordsExist)
 
 	scenario.Step(`^this "([^"]*)" record exists:$`, s.ThenThisRecordExists)
-	scenario.Step(`^no "([^"]*)" record exists with id "([^"]*)"$`, s.ThenNoRecordExists)
+	scenario.Step(`^no "([^"]*)" record exists with (\w+) "([^"]*)"$`, s.ThenNoRecordExists)
 
 	return nil
 }
@@ -95,8 +95,11 @@ func (s *DatabaseSteps) ThenThisRecordExists(ctx context.Context, table string,
 		return fmt.Errorf("no primary key found for table %s", table)
 	}
 
+	// The record is looked up by the primary key columns it contains, columns
+	// of a composite key that are left out (e.g. with a default) are ignored.
 	columns := []string{}
-	primaryvalues := make([]any, len(primarykeys))
+	where := []string{"1=1"}
+	primaryvalues := []any{}
 	for _, row := range record.Rows {
 		columns = append(columns, row.Cells[0].Value)
 		if ix := sort.SearchStrings(primarykeys, row.Cells[0].Value); ix < len(primarykeys) && primarykeys[ix] == row.Cells[0].Value {
@@ -104,14 +107,15 @@ func (s *DatabaseSteps) ThenThisRecordExists(ctx context.Context, table string,
 			if n, ok := strconv.ParseInt(row.Cells[1].Value, 10, 64); ok == nil {
 				val = n
 			}
-			primaryvalues[ix] = val
+			primaryvalues = append(primaryvalues, val)
+			where = append(where, primarykeys[ix]+" = $"+strconv.Itoa(len(primaryvalues)))
 		}
 	}
-
-	q := "SELECT " + strings.Join(columns, ", ") + " FROM " + table + " WHERE 1=1"
-	for i, pk := range primarykeys {
-		q += " AND " + pk + " = $" + strconv.Itoa(i+1)
+	if len(primaryvalues) == 0 {
+		return fmt.Errorf("record should contain one of the primary keys %v of table %s", primarykeys, table)
 	}
+
+	q := "SELECT " + strings.Join(columns, ", ") + " FROM " + table + " WHERE " + strings.Join(where, " AND ")
 	row := s.DB.QueryRowContext(ctx, q, primaryvalues...)
 	var values []interface{}
 	for i := 0; i < len(columns); i++ {
@@ -170,15 +174,22 @@ func (s *DatabaseSteps) ThenThisRecordExists(ctx context.Context, table string,
 	return nil
 }
 
-func (s *DatabaseSteps) ThenNoRecordExists(ctx context.Context, table, id string) error {
-	q := `SELECT COUNT(*) FROM ` + table + ` WHERE "id" = $1`
-	row := s.DB.QueryRowContext(ctx, q, id)
+func (s *DatabaseSteps) ThenNoRecordExists(ctx context.Context, table, column, value string) error {
+	primarykeys, err := s.fetchPrimaryKeys(ctx, table)
+	if err != nil {
+		return err
+	}
+	if ix := sort.SearchStrings(primarykeys, column); ix == len(primarykeys) || primarykeys[ix] != column {
+		return fmt.Errorf("%s is not a primary key of table %s, got %v", column, table, primarykeys)
+	}
+	q := `SELECT COUNT(*) FROM "` + table + `" WHERE "` + column + `" = $1`
+	row := s.DB.QueryRowContext(ctx, q, value)
 	var count int
 	if err := row.Scan(&count); err != nil {
 		return err
 	}
 	if count != 0 {
-		return fmt.Errorf("expected no record with id %q, got %d", id, count)
+		return fmt.Errorf("expected no record with %s %q, got %d", column, value, count)
 	}
 	return nil
 }
@@ -188,7 +199,7 @@ func (s *DatabaseSteps) fetchPrimaryKeys(ctx context.Context, table string) ([]s
 	pkquery := `
 		SELECT l.name
 		FROM pragma_table_info("` + table + `") AS l
-		WHERE l.pk = 1
+		WHERE l.pk > 0
 	`
 	rows, err := s.DB.QueryContext(ctx, pkquery)
 	if err != nil {
@@ -7,6 +7,8 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/cucumber/godog"
@@ -21,6 +23,10 @@ type HTTPSteps struct {
 	ExtraHeaders http.Header
 	Request      *http.Request
 	Response     *httptest.ResponseRecorder
+
+	// Variables are values saved from earlier responses, they can be used in
+	// paths and request bodies as {{name}}.
+	Variables map[string]string
 }
 
 func (s *HTTPSteps) InitializeSuite(suite *godog.TestSuiteContext) error {
@@ -32,20 +38,31 @@ func (s *HTTPSteps) InitializeScenario(scenario *godog.ScenarioContext) error {
 		s.ExtraHeaders = make(http.Header)
 		s.Request = nil
 		s.Response = nil
+		s.Variables = make(map[string]string)
 		return ctx, nil
 	})
 
 	scenario.Step(`^the client's remote address is "([^"]+)"$`, s.GivenClientRemoteAddr)
+	scenario.Step(`^the client sends the header "([^"]+)" with "(.*)"$`, s.GivenClientSendsHeader)
+	scenario.Step(`^the client stops sending the header "([^"]+)"$`, s.GivenClientStopsSendingHeader)
 
 	scenario.Step(`^the client does a ([^ ]*) request to "([^"]+)"$`, s.WhenClientRequests)
 	scenario.Step(`^the client does a ([^ ]*) request to "([^"]+)" with the following data:$`, s.WhenClientRequestsWithData)
 	scenario.Step(`^the client does a ([^ ]*) request to "([^"]+)" with the following headers:$`, s.WhenClientRequestsWithHeaders)
 
 	scenario.Step(`^the response code should be (\d+) \([^\)]+\)$`, s.ThenStatusShouldBe)
-	scenario.Step(`^the response header "([^"]*)" should be "([^"]*)"$`, s.ThenHeaderShouldBe)
+	scenario.Step(`^the response header "([^"]*)" should be "(.*)"$`, s.ThenHeaderShouldBe)
 	scenario.Step(`^the response header "([^"]*)" should be not set$`, s.ThenHeaderShouldBeNotSet)
 	scenario.Step(`^the response body should be the following "([^"]+)":$`, s.ThenResponseBodyShouldBe)
+	scenario.Step(`^the response body should be the following "([^"]+)" lines:$`, s.ThenResponseBodyShouldBeLines)
 	scenario.Step(`^the response body should be empty$`, s.ThenResponseBodyShouldBeEmpty)
+	scenario.Step(`^the response should be a problem with status (\d+) \(([^\)]+)\)$`, s.ThenResponseShouldBeProblem)
+	scenario.Step(`^the problem detail should be "([^"]*)"$`, s.ThenProblemDetailShouldBe)
+	scenario.Step(`^the problem should have a field error for "([^"]*)" saying "([^"]*)"$`, s.ThenProblemShouldHaveFieldError)
+	scenario.Step(`^the response JSON field "([^"]*)" should be "([^"]*)"$`, s.ThenJSONFieldShouldBe)
+	scenario.Step(`^the response JSON field "([^"]*)" should match "([^"]*)"$`, s.ThenJSONFieldShouldMatch)
+	scenario.Step(`^the response JSON field "([^"]*)" should be not set$`, s.ThenJSONFieldShouldBeNotSet)
+	scenario.Step(`^the response JSON field "([^"]*)" is saved as "([^"]*)"$`, s.ThenSaveJSONField)
 
 	return nil
 }
@@ -71,6 +88,11 @@ func (s *HTTPSteps) doRequest(ctx context.Context) error {
 	for k, v := range s.ExtraHeaders {
 		s.Request.Header[k] = v
 	}
+	// Like the http server, move the Host header to the request.
+	if host := s.Request.Header.Get("Host"); host != "" {
+		s.Request.Host = host
+		s.Request.Header.Del("Host")
+	}
 	handler := s.determinHandler()
 	if handler == nil {
 		return fmt.Errorf("no handler was set")
@@ -79,29 +101,100 @@ func (s *HTTPSteps) doRequest(ctx context.Context) error {
 	return nil
 }
 
+// expand replaces {{name}} with the saved variables.
+func (s *HTTPSteps) expand(in string) string {
+	for name, value := range s.Variables {
+		in = strings.ReplaceAll(in, "{{"+name+"}}", value)
+	}
+	return in
+}
+
+// jsonField looks up a dot separated path (e.g. "items.0.key") in the JSON
+// response body.
+func (s *HTTPSteps) jsonField(path string) (any, bool, error) {
+	if s.Response == nil {
+		return nil, false, fmt.Errorf("no request was made")
+	}
+	var current any
+	if err := json.Unmarshal(s.Response.Body.Bytes(), &current); err != nil {
+		return nil, false, fmt.Errorf("response body is not JSON: %w", err)
+	}
+	for _, part := range strings.Split(path, ".") {
+		switch v := current.(type) {
+		case map[string]any:
+			value, ok := v[part]
+			if !ok {
+				return nil, false, nil
+			}
+			current = value
+		case []any:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false, nil
+			}
+			current = v[i]
+		default:
+			return nil, false, nil
+		}
+	}
+	return current, true, nil
+}
+
+func (s *HTTPSteps) jsonFieldString(path string) (string, error) {
+	value, found, err := s.jsonField(path)
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return "", fmt.Errorf("field %q not found in response: %s", path, strings.TrimSpace(s.Response.Body.String()))
+	}
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case nil:
+		return "<nil>", nil
+	case map[string]any, []any:
+		raw, err := json.Marshal(v)
+		return string(raw), err
+	default:
+		return fmt.Sprintf("%v", v), nil
+	}
+}
+
 func (s *HTTPSteps) GivenClientRemoteAddr(ctx context.Context, addr string) error {
 	s.ExtraHeaders.Set("X-Forwarded-For", addr)
 	return nil
 }
 
+// GivenClientSendsHeader adds the header to all following requests.
+func (s *HTTPSteps) GivenClientSendsHeader(ctx context.Context, key, value string) error {
+	s.ExtraHeaders.Set(key, s.expand(value))
+	return nil
+}
+
+func (s *HTTPSteps) GivenClientStopsSendingHeader(ctx context.Context, key string) error {
+	s.ExtraHeaders.Del(key)
+	return nil
+}
+
 func (s *HTTPSteps) WhenClientRequests(ctx context.Context, method, path string) error {
-	s.Request = httptest.NewRequest(method, path, nil)
+	s.Request = httptest.NewRequest(method, s.expand(path), nil)
 	s.Response = httptest.NewRecorder()
 	return s.doRequest(ctx)
 }
 
 func (s *HTTPSteps) WhenClientRequestsWithHeaders(ctx context.Context, method, path string, headers *godog.Table) error {
-	s.Request = httptest.NewRequest(method, path, nil)
+	s.Request = httptest.NewRequest(method, s.expand(path), nil)
 	for _, row := range headers.Rows {
-		s.Request.Header.Set(row.Cells[0].Value, row.Cells[1].Value)
+		s.Request.Header.Set(row.Cells[0].Value, s.expand(row.Cells[1].Value))
 	}
 	s.Response = httptest.NewRecorder()
 	return s.doRequest(ctx)
 }
 
 func (s *HTTPSteps) WhenClientRequestsWithData(ctx context.Context, method, path string, data *godog.DocString) error {
-	body := io.NopCloser(strings.NewReader(data.Content))
-	s.Request = httptest.NewRequest(method, path, body)
+	body := io.NopCloser(strings.NewReader(s.expand(data.Content)))
+	s.Request = httptest.NewRequest(method, s.expand(path), body)
 	s.Response = httptest.NewRecorder()
 	return s.doRequest(ctx)
 }
@@ -121,6 +214,7 @@ func (s *HTTPSteps) ThenHeaderShouldBe(ctx context.Context, key, value string) e
 	if s.Response == nil {
 		return fmt.Errorf("no request was made")
 	}
+	value = s.expand(value)
 	got := s.Response.Header().Get(key)
 	if got != value {
 		return fmt.Errorf("expected header %q to be %q, got %q", key, value, got)
@@ -167,6 +261,22 @@ func (s *HTTPSteps) ThenResponseBodyShouldBe(ctx context.Context, expectedConten
 	return nil
 }
 
+// ThenResponseBodyShouldBeLines compares a body of lines, e.g. JSON Lines or
+// CSV, that each end with a newline. Doc strings can't end with a newline, so
+// the last one is added to the expected body.
+func (s *HTTPSteps) ThenResponseBodyShouldBeLines(ctx context.Context, expectedContentType string, body *godog.DocString) error {
+	if s.Response == nil {
+		return fmt.Errorf("no request was made")
+	}
+	if contentType := s.Response.Header().Get("Content-Type"); contentType != expectedContentType {
+		return fmt.Errorf("expected content type %q, got %q", expectedContentType, contentType)
+	}
+	if diff := cmp.Diff(body.Content+"\n", s.Response.Body.String()); diff != "" {
+		return fmt.Errorf("body mismatch (-want +got):\n%s", diff)
+	}
+	return nil
+}
+
 func (s *HTTPSteps) ThenResponseBodyShouldBeEmpty(ctx context.Context) error {
 	if s.Response == nil {
 		return fmt.Errorf("no request was made")
@@ -176,3 +286,116 @@ func (s *HTTPSteps) ThenResponseBodyShouldBeEmpty(ctx context.Context) error {
 	}
 	return nil
 }
+
+func (s *HTTPSteps) ThenJSONFieldShouldBe(ctx context.Context, path, want string) error {
+	got, err := s.jsonFieldString(path)
+	if err != nil {
+		return err
+	}
+	if got != s.expand(want) {
+		return fmt.Errorf("expected field %q to be %q, got %q", path, s.expand(want), got)
+	}
+	return nil
+}
+
+func (s *HTTPSteps) ThenJSONFieldShouldMatch(ctx context.Context, path, pattern string) error {
+	got, err := s.jsonFieldString(path)
+	if err != nil {
+		return err
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+	if !re.MatchString(got) {
+		return fmt.Errorf("expected field %q to match %q, got %q", path, pattern, got)
+	}
+	return nil
+}
+
+func (s *HTTPSteps) ThenJSONFieldShouldBeNotSet(ctx context.Context, path string) error {
+	value, found, err := s.jsonField(path)
+	if err != nil {
+		return err
+	}
+	if found {
+		return fmt.Errorf("expected field %q to be not set, got %v", path, value)
+	}
+	return nil
+}
+
+func (s *HTTPSteps) ThenSaveJSONField(ctx context.Context, path, name string) error {
+	got, err := s.jsonFieldString(path)
+	if err != nil {
+		return err
+	}
+	s.Variables[name] = got
+	return nil
+}
+
+// problem is the subset of a problem+json response the steps assert on.
+type problem struct {
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail"`
+	Errors []struct {
+		Field   string `json:"field"`
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+func (s *HTTPSteps) problem() (*problem, error) {
+	if s.Response == nil {
+		return nil, fmt.Errorf("no request was made")
+	}
+	body := strings.TrimSpace(s.Response.Body.String())
+	if contentType := s.Response.Header().Get("Content-Type"); contentType != "application/problem+json" {
+		return nil, fmt.Errorf("expected a problem response, got content type %q (%v)", contentType, body)
+	}
+	p := &problem{}
+	if err := json.Unmarshal([]byte(body), p); err != nil {
+		return nil, fmt.Errorf("invalid problem response: %w", err)
+	}
+	return p, nil
+}
+
+func (s *HTTPSteps) ThenResponseShouldBeProblem(ctx context.Context, status int, title string) error {
+	if err := s.ThenStatusShouldBe(ctx, status); err != nil {
+		return err
+	}
+	p, err := s.problem()
+	if err != nil {
+		return err
+	}
+	if p.Status != status {
+		return fmt.Errorf("expected problem status %d, got %d", status, p.Status)
+	}
+	if p.Title != title {
+		return fmt.Errorf("expected problem title %q, got %q", title, p.Title)
+	}
+	return nil
+}
+
+func (s *HTTPSteps) ThenProblemDetailShouldBe(ctx context.Context, detail string) error {
+	p, err := s.problem()
+	if err != nil {
+		return err
+	}
+	if p.Detail != detail {
+		return fmt.Errorf("expected problem detail %q, got %q", detail, p.Detail)
+	}
+	return nil
+}
+
+func (s *HTTPSteps) ThenProblemShouldHaveFieldError(ctx context.Context, field, message string) error {
+	p, err := s.problem()
+	if err != nil {
+		return err
+	}
+	for _, e := range p.Errors {
+		if e.Field == field && e.Message == message {
+			return nil
+		}
+	}
+	return fmt.Errorf("expected field error %q for %q, got %+v", message, field, p.Errors)
+}
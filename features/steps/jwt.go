@@ -0,0 +1,113 @@
+package steps
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/cucumber/godog"
+)
+
+// JWTSteps issue JWTs for the client. The public keys are served as JWKS by a
+// stub issuer at JWKSURL, except for the "unknown" key.
+type JWTSteps struct {
+	HTTP    *HTTPSteps
+	JWKSURL string
+
+	keys   map[string]crypto.Signer
+	server *httptest.Server
+}
+
+func (s *JWTSteps) InitializeSuite(suite *godog.TestSuiteContext) error {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return err
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	unknownKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	s.keys = map[string]crypto.Signer{"rsa": rsaKey, "ec": ecKey, "unknown": unknownKey}
+
+	jwks, err := json.Marshal(map[string]any{"keys": []map[string]string{
+		{"kid": "rsa", "kty": "RSA", "use": "sig", "n": encodeBigInt(rsaKey.N), "e": encodeBigInt(big.NewInt(int64(rsaKey.E)))},
+		{"kid": "ec", "kty": "EC", "use": "sig", "crv": "P-256", "x": encodeBigInt(ecKey.X), "y": encodeBigInt(ecKey.Y)},
+	}})
+	if err != nil {
+		return err
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(jwks)
+	}))
+	s.JWKSURL = s.server.URL + "/.well-known/jwks.json"
+	suite.AfterSuite(s.server.Close)
+	return nil
+}
+
+func (s *JWTSteps) InitializeScenario(scenario *godog.ScenarioContext) error {
+	scenario.Step(`^the client sends a JWT signed with the "([^"]*)" key with the following claims:$`, s.GivenClientSendsJWT)
+	return nil
+}
+
+// GivenClientSendsJWT signs the claims with the named key (rsa, ec or unknown)
+// and sends the token as bearer token with all following requests.
+func (s *JWTSteps) GivenClientSendsJWT(ctx context.Context, name string, claims *godog.DocString) error {
+	key, ok := s.keys[name]
+	if !ok {
+		return fmt.Errorf("unknown key %q", name)
+	}
+	alg := "ES256"
+	if _, ok := key.(*rsa.PrivateKey); ok {
+		alg = "RS256"
+	}
+	header, err := json.Marshal(map[string]string{"alg": alg, "typ": "JWT", "kid": name})
+	if err != nil {
+		return err
+	}
+	var payload any
+	if err := json.Unmarshal([]byte(s.HTTP.expand(claims.Content)), &payload); err != nil {
+		return fmt.Errorf("invalid claims: %w", err)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(body)
+	digest := sha256.Sum256([]byte(input))
+	var signature []byte
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, key, digest[:])
+		if err == nil {
+			signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	s.HTTP.ExtraHeaders.Set("Authorization", "Bearer "+input+"."+base64.RawURLEncoding.EncodeToString(signature))
+	return nil
+}
+
+func encodeBigInt(n *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(n.Bytes())
+}
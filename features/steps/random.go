@@ -0,0 +1,33 @@
+package steps
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"strings"
+
+	"github.com/cucumber/godog"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/util/randutil"
+)
+
+type RandomSteps struct{}
+
+func (s *RandomSteps) InitializeSuite(suite *godog.TestSuiteContext) error {
+	return nil
+}
+
+func (s *RandomSteps) InitializeScenario(scenario *godog.ScenarioContext) error {
+	scenario.Step(`^the random bytes are "([0-9a-f ]*)"$`, s.GivenTheRandomBytesAre)
+	return nil
+}
+
+// GivenTheRandomBytesAre makes the following steps read the given hex encoded
+// bytes (spaces are ignored) as random bytes, see randutil.Reader. Reading
+// more fails.
+func (s *RandomSteps) GivenTheRandomBytesAre(ctx context.Context, value string) (context.Context, error) {
+	raw, err := hex.DecodeString(strings.ReplaceAll(value, " ", ""))
+	if err != nil {
+		return ctx, err
+	}
+	return randutil.WithReader(ctx, bytes.NewReader(raw)), nil
+}
@@ -0,0 +1,30 @@
+package steps
+
+import (
+	"context"
+	"time"
+
+	"github.com/cucumber/godog"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/util/timeutil"
+)
+
+type TimeSteps struct{}
+
+func (s *TimeSteps) InitializeSuite(suite *godog.TestSuiteContext) error {
+	return nil
+}
+
+func (s *TimeSteps) InitializeScenario(scenario *godog.ScenarioContext) error {
+	scenario.Step(`^the current time is "([^"]*)"$`, s.GivenTheCurrentTimeIs)
+	return nil
+}
+
+// GivenTheCurrentTimeIs moves the time of all following steps to the given
+// timestamp, see timeutil.Now.
+func (s *TimeSteps) GivenTheCurrentTimeIs(ctx context.Context, value string) (context.Context, error) {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return ctx, err
+	}
+	return timeutil.WithTime(ctx, t), nil
+}
@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// apiKeyPrefix starts every API key, so keys are recognizable in e.g. logs and
+// secret scanners.
+const apiKeyPrefix = "rk_"
+
+// APIKey is an issued API key. Only a hash of the key is stored, the key itself
+// is returned once when it's issued. Keys that aren't admin can only use their
+// Namespaces.
+type APIKey struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Role       Role       `json:"role"`
+	Namespaces []string   `json:"namespaces,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// namespaces are stored as a JSON list, NULL when empty.
+type namespaces []string
+
+func (n namespaces) Value() (driver.Value, error) {
+	if len(n) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal([]string(n))
+	return string(data), err
+}
+
+func (n *namespaces) Scan(src any) error {
+	*n = nil
+	switch v := src.(type) {
+	case nil:
+		return nil
+	case string:
+		return json.Unmarshal([]byte(v), (*[]string)(n))
+	case []byte:
+		return json.Unmarshal(v, (*[]string)(n))
+	}
+	return fmt.Errorf("can not scan %T into namespaces", src)
+}
+
+// APIKeys authenticates bearer tokens against the API keys in the database.
+type APIKeys struct {
+	db *sql.DB
+}
+
+// NewAPIKeys creates an Authenticator for the API keys in db.
+func NewAPIKeys(db *sql.DB) *APIKeys {
+	return &APIKeys{db: db}
+}
+
+// hashKey returns the stored form of an API key. Keys are random, so a fast
+// hash without salt is enough.
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func (a *APIKeys) Authenticate(ctx context.Context, token string) (*Principal, error) {
+	if !strings.HasPrefix(token, apiKeyPrefix) {
+		return nil, ErrInvalidCredentials
+	}
+	var id int64
+	var name string
+	var role Role
+	var allowed namespaces
+	err := a.db.QueryRowContext(ctx, "SELECT id, name, role, namespaces FROM api_key WHERE hash = ? AND revoked_at IS NULL", hashKey(token)).Scan(&id, &name, &role, &allowed)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Principal{Subject: name, Method: "api_key", KeyID: id, Role: role, Namespaces: allowed}, nil
+}
+
+// Issue creates a new API key with the given name, role and namespaces, it
+// returns the key which can't be retrieved later.
+func (a *APIKeys) Issue(ctx context.Context, name string, role Role, allowed []string, now time.Time) (string, *APIKey, error) {
+	random := make([]byte, 24)
+	if _, err := rand.Read(random); err != nil {
+		return "", nil, err
+	}
+	key := apiKeyPrefix + hex.EncodeToString(random)
+	apiKey := &APIKey{
+		Name:       name,
+		Prefix:     key[:len(apiKeyPrefix)+6],
+		Role:       role,
+		Namespaces: allowed,
+		CreatedAt:  now.UTC(),
+	}
+	result, err := a.db.ExecContext(ctx, "INSERT INTO api_key (name, prefix, role, namespaces, hash, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		apiKey.Name, apiKey.Prefix, apiKey.Role, namespaces(apiKey.Namespaces), hashKey(key), apiKey.CreatedAt)
+	if err != nil {
+		return "", nil, err
+	}
+	if apiKey.ID, err = result.LastInsertId(); err != nil {
+		return "", nil, err
+	}
+	return key, apiKey, nil
+}
+
+// List returns all API keys, including the revoked ones.
+func (a *APIKeys) List(ctx context.Context) ([]*APIKey, error) {
+	rows, err := a.db.QueryContext(ctx, "SELECT id, name, prefix, role, namespaces, created_at, revoked_at FROM api_key ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := []*APIKey{}
+	for rows.Next() {
+		key := &APIKey{}
+		var revokedAt sql.NullTime
+		if err := rows.Scan(&key.ID, &key.Name, &key.Prefix, &key.Role, (*namespaces)(&key.Namespaces), &key.CreatedAt, &revokedAt); err != nil {
+			return nil, err
+		}
+		if revokedAt.Valid {
+			key.RevokedAt = &revokedAt.Time
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// Revoke revokes the API key, it returns false when there is no such key that
+// isn't revoked yet.
+func (a *APIKeys) Revoke(ctx context.Context, id int64, now time.Time) (bool, error) {
+	result, err := a.db.ExecContext(ctx, "UPDATE api_key SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL", now.UTC(), id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
@@ -0,0 +1,111 @@
+// auth authenticates the callers of the API. An Authenticator turns the
+// credentials of a request into a Principal, which is kept in the request
+// context for the handlers.
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrNoCredentials is returned when a request has no credentials.
+	ErrNoCredentials = errors.New("authentication required")
+	// ErrInvalidCredentials is returned for credentials that are unknown,
+	// revoked or otherwise not valid.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	// Subject identifies the caller, e.g. the name of an API key.
+	Subject string `json:"subject"`
+	// Method is how the caller authenticated, e.g. "api_key" or "jwt".
+	Method string `json:"method"`
+	// KeyID is the id of the API key the caller authenticated with, if any.
+	KeyID int64 `json:"key_id,omitempty"`
+	// Issuer is the issuer of the JWT the caller authenticated with, if any.
+	Issuer string `json:"issuer,omitempty"`
+	// Role is what the caller is allowed to do, empty when it has no role.
+	Role Role `json:"role,omitempty"`
+	// Namespaces are the namespaces the caller can use, admins can use all of
+	// them.
+	Namespaces []string `json:"namespaces,omitempty"`
+	// Claims are all claims of the token the caller authenticated with, if
+	// it's a JWT.
+	Claims map[string]any `json:"claims,omitempty"`
+}
+
+// ID identifies the caller across authentication methods, e.g. "api_key:12"
+// or "jwt:https://issuer.example:alice". Unlike the Subject it can't collide
+// between an API key and a JWT, or with a revoked API key with the same name,
+// so it's what owns redirections.
+func (p *Principal) ID() string {
+	switch p.Method {
+	case "api_key":
+		return p.Method + ":" + strconv.FormatInt(p.KeyID, 10)
+	case "jwt":
+		return p.Method + ":" + p.Issuer + ":" + p.Subject
+	}
+	return p.Method + ":" + p.Subject
+}
+
+// Authenticator validates the credentials of a request.
+type Authenticator interface {
+	// Authenticate returns the principal of the given bearer token, or
+	// ErrInvalidCredentials (possibly wrapped) when the token is not valid.
+	Authenticate(ctx context.Context, token string) (*Principal, error)
+}
+
+// Chain tries each authenticator in order, the principal of the first that
+// accepts the token is used.
+type Chain []Authenticator
+
+func (c Chain) Authenticate(ctx context.Context, token string) (*Principal, error) {
+	result := ErrInvalidCredentials
+	for _, authenticator := range c {
+		principal, err := authenticator.Authenticate(ctx, token)
+		if err == nil {
+			return principal, nil
+		}
+		if !errors.Is(err, ErrInvalidCredentials) {
+			return nil, err
+		}
+		// Keep the reason of an authenticator that recognized the token.
+		if err != ErrInvalidCredentials {
+			result = err
+		}
+	}
+	return nil, result
+}
+
+// BearerToken returns the token in the Authorization header of the request,
+// or ErrNoCredentials when there is none.
+func BearerToken(r *http.Request) (string, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return "", ErrNoCredentials
+	}
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+		return "", ErrInvalidCredentials
+	}
+	return strings.TrimSpace(token), nil
+}
+
+type contextKey struct{}
+
+// WithPrincipal returns a context carrying the principal.
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, principal)
+}
+
+// GetPrincipal returns the principal of the context, or nil for public
+// requests.
+func GetPrincipal(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(contextKey{}).(*Principal)
+	return principal
+}
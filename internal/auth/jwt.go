@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/util/timeutil"
+)
+
+// leeway allows for clock skew between the issuer and the service when the
+// times of a token are checked.
+const leeway = 30 * time.Second
+
+// JWT authenticates bearer tokens that are JSON Web Tokens signed (RS256 or
+// ES256) by one of the keys of a KeySet.
+type JWT struct {
+	keys     *KeySet
+	issuer   string
+	audience string
+
+	// RoleClaim is the claim with the role (or list of roles) of the caller,
+	// the most privileged known role is used.
+	RoleClaim string
+	// NamespaceClaim is the claim with the namespace (or list of namespaces)
+	// the caller can use.
+	NamespaceClaim string
+}
+
+// NewJWT creates an Authenticator for tokens signed by the keys. When issuer or
+// audience are not empty the iss and aud claims of a token have to match.
+func NewJWT(keys *KeySet, issuer, audience string) *JWT {
+	return &JWT{keys: keys, issuer: issuer, audience: audience, RoleClaim: "roles", NamespaceClaim: "namespaces"}
+}
+
+// audience is the aud claim, which is either a string or a list of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(a))
+}
+
+// claims are the registered claims that are checked.
+type claims struct {
+	Subject   string   `json:"sub"`
+	Issuer    string   `json:"iss"`
+	Audience  audience `json:"aud"`
+	ExpiresAt *float64 `json:"exp"`
+	NotBefore *float64 `json:"nbf"`
+}
+
+// invalid returns ErrInvalidCredentials with the reason the token is invalid.
+func invalid(reason string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidCredentials, reason)
+}
+
+func (j *JWT) Authenticate(ctx context.Context, token string) (*Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		// Not a JWT, so leave it to the other authenticators.
+		return nil, ErrInvalidCredentials
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, invalid("malformed token")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, invalid("malformed token")
+	}
+	key := j.keys.key(header.Kid)
+	if key == nil {
+		return nil, invalid("unknown signing key")
+	}
+	if !verify(header.Alg, key, parts[0]+"."+parts[1], signature) {
+		return nil, invalid("invalid signature")
+	}
+
+	var all map[string]any
+	var registered claims
+	if decodeSegment(parts[1], &all) != nil || decodeSegment(parts[1], &registered) != nil {
+		return nil, invalid("malformed claims")
+	}
+	now := timeutil.Now(ctx)
+	switch {
+	case registered.ExpiresAt == nil:
+		return nil, invalid("token has no expiry")
+	case now.After(unixTime(*registered.ExpiresAt).Add(leeway)):
+		return nil, invalid("token is expired")
+	case registered.NotBefore != nil && now.Add(leeway).Before(unixTime(*registered.NotBefore)):
+		return nil, invalid("token is not valid yet")
+	case j.issuer != "" && registered.Issuer != j.issuer:
+		return nil, invalid("unexpected issuer")
+	case j.audience != "" && !slices.Contains(registered.Audience, j.audience):
+		return nil, invalid("unexpected audience")
+	case registered.Subject == "":
+		return nil, invalid("token has no subject")
+	}
+	principal := &Principal{
+		Subject:    registered.Subject,
+		Method:     "jwt",
+		Issuer:     registered.Issuer,
+		Role:       j.role(all),
+		Namespaces: stringsClaim(all, j.NamespaceClaim),
+		Claims:     all,
+	}
+	return principal, nil
+}
+
+// role returns the most privileged known role in the role claim.
+func (j *JWT) role(claims map[string]any) Role {
+	var result Role
+	for _, name := range stringsClaim(claims, j.RoleClaim) {
+		if role, ok := ParseRole(name); ok && role.Includes(result) {
+			result = role
+		}
+	}
+	return result
+}
+
+// stringsClaim returns the claim that is either a string or a list of
+// strings, other values are ignored.
+func stringsClaim(claims map[string]any, name string) []string {
+	var values []string
+	switch v := claims[name].(type) {
+	case string:
+		values = []string{v}
+	case []any:
+		for _, value := range v {
+			if s, ok := value.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+	return values
+}
+
+// verify checks the signature of the signing input with the key, the key has
+// to match the algorithm.
+func verify(alg string, key any, input string, signature []byte) bool {
+	digest := sha256.Sum256([]byte(input))
+	switch alg {
+	case "RS256":
+		key, ok := key.(*rsa.PublicKey)
+		return ok && rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+	case "ES256":
+		key, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(key, digest[:], r, s)
+	}
+	return false
+}
+
+func decodeSegment(segment string, v any) error {
+	raw, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
+
+func unixTime(seconds float64) time.Time {
+	return time.Unix(0, int64(seconds*float64(time.Second)))
+}
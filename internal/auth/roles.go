@@ -0,0 +1,29 @@
+package auth
+
+import "slices"
+
+// Role is what a principal is allowed to do, each role includes the ones
+// before it: viewers can read, editors can also change redirections and admins
+// can do everything, including managing the redirections of others.
+type Role string
+
+const (
+	RoleViewer Role = "viewer"
+	RoleEditor Role = "editor"
+	RoleAdmin  Role = "admin"
+)
+
+// roles are all roles, from least to most privileged.
+var roles = []Role{RoleViewer, RoleEditor, RoleAdmin}
+
+// ParseRole returns the role with the given name.
+func ParseRole(name string) (Role, bool) {
+	role := Role(name)
+	return role, slices.Contains(roles, role)
+}
+
+// Includes reports if the role grants everything the required role does. Any
+// role, including none, includes the empty role.
+func (r Role) Includes(required Role) bool {
+	return slices.Index(roles, r) >= slices.Index(roles, required)
+}
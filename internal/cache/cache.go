@@ -0,0 +1,113 @@
+// cache is an in-memory least recently used cache whose entries expire. It's
+// safe for concurrent use and keeps counters of its hits and misses.
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats are the counters of a Cache.
+type Stats struct {
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Evictions int64 `json:"evictions"`
+	Size      int   `json:"size"`
+}
+
+type entry[K comparable, V any] struct {
+	key     K
+	value   V
+	expires time.Time
+}
+
+// Cache holds up to size entries, the least recently used entry is evicted to
+// make room for a new one. A cache with a size of zero stores nothing.
+type Cache[K comparable, V any] struct {
+	size int
+
+	mu      sync.Mutex
+	entries map[K]*list.Element
+	// recent has the entries in order of use, the most recent in front.
+	recent *list.List
+
+	hits      atomic.Int64
+	misses    atomic.Int64
+	evictions atomic.Int64
+}
+
+// New creates a Cache that holds up to size entries.
+func New[K comparable, V any](size int) *Cache[K, V] {
+	return &Cache[K, V]{
+		size:    max(size, 0),
+		entries: map[K]*list.Element{},
+		recent:  list.New(),
+	}
+}
+
+// Get returns the value of key when it's cached and not expired at now.
+func (c *Cache[K, V]) Get(key K, now time.Time) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		e := el.Value.(*entry[K, V])
+		if now.Before(e.expires) {
+			c.recent.MoveToFront(el)
+			c.hits.Add(1)
+			return e.value, true
+		}
+		c.remove(el)
+	}
+	c.misses.Add(1)
+	var zero V
+	return zero, false
+}
+
+// Set caches the value of key until it expires, evicting the least recently
+// used entry when the cache is full.
+func (c *Cache[K, V]) Set(key K, value V, expires time.Time) {
+	if c.size == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		el.Value = &entry[K, V]{key: key, value: value, expires: expires}
+		c.recent.MoveToFront(el)
+		return
+	}
+	if c.recent.Len() >= c.size {
+		c.remove(c.recent.Back())
+		c.evictions.Add(1)
+	}
+	c.entries[key] = c.recent.PushFront(&entry[K, V]{key: key, value: value, expires: expires})
+}
+
+// Delete removes key from the cache, e.g. when its value has changed.
+func (c *Cache[K, V]) Delete(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+}
+
+func (c *Cache[K, V]) remove(el *list.Element) {
+	c.recent.Remove(el)
+	delete(c.entries, el.Value.(*entry[K, V]).key)
+}
+
+// Stats returns the counters of the cache.
+func (c *Cache[K, V]) Stats() Stats {
+	c.mu.Lock()
+	size := c.recent.Len()
+	c.mu.Unlock()
+	return Stats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+		Size:      size,
+	}
+}
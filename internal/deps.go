@@ -4,18 +4,183 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"net/url"
 	"os"
 	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/auth"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/hits"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/rules"
 	"github.com/koenbollen/go-tested-api-with-sqlite/migrations"
+	"github.com/koenbollen/logging"
 )
 
+// Config is the configuration of the service. Each field is read from the
+// environment variable in its env tag, or set to the value in its default tag.
+// Supported field types are string, int, bool, time.Duration and []string
+// (comma separated).
 type Config struct {
-	DSN string `default:":memory:?cache=shared"`
+	// DSN is the sqlite data source name. Setup adds the _time_format
+	// parameter when it's missing, so timestamps are stored in a format
+	// sqlite's date functions understand.
+	DSN string `env:"DSN" default:":memory:?cache=shared&_time_format=sqlite"`
+
+	// AllowedSchemes are the URL schemes redirections are allowed to point to.
+	AllowedSchemes []string `env:"ALLOWED_SCHEMES" default:"http,https"`
+	// AllowedHosts, when not empty, limits redirections to these hosts (and
+	// their subdomains).
+	AllowedHosts []string `env:"ALLOWED_HOSTS"`
+	// DeniedHosts are hosts (and their subdomains) redirections can never point
+	// to, these take precedence over AllowedHosts.
+	DeniedHosts []string `env:"DENIED_HOSTS"`
+
+	// KeyMinLength and KeyMaxLength limit the length of redirection keys.
+	KeyMinLength int `env:"KEY_MIN_LENGTH" default:"1"`
+	KeyMaxLength int `env:"KEY_MAX_LENGTH" default:"64"`
+	// KeyCharset are the characters a redirection key can consist of.
+	KeyCharset string `env:"KEY_CHARSET" default:"abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_"`
+	// ReservedKeys can not be used as redirection key, in addition to the keys
+	// that collide with other routes.
+	ReservedKeys []string `env:"RESERVED_KEYS"`
+	// GeneratedKeyAlphabet and GeneratedKeyLength are used to generate a key
+	// when a redirection is created without one. The default alphabet leaves
+	// out characters that are easily confused.
+	GeneratedKeyAlphabet string `env:"GENERATED_KEY_ALPHABET" default:"23456789abcdefghijkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ"`
+	GeneratedKeyLength   int    `env:"GENERATED_KEY_LENGTH" default:"7"`
+
+	// HitBufferSize is the number of hits kept in memory before new hits are
+	// dropped, these are written in batches of HitBatchSize or every
+	// HitFlushInterval.
+	HitBufferSize    int           `env:"HIT_BUFFER_SIZE" default:"10000"`
+	HitBatchSize     int           `env:"HIT_BATCH_SIZE" default:"100"`
+	HitFlushInterval time.Duration `env:"HIT_FLUSH_INTERVAL" default:"1s"`
+
+	// SweepInterval is how often Sweep runs in the background, zero disables
+	// it. Expired redirections are purged after ExpiredRetention and deleted
+	// ones after DeletedRetention.
+	SweepInterval    time.Duration `env:"SWEEP_INTERVAL" default:"1m"`
+	ExpiredRetention time.Duration `env:"EXPIRED_RETENTION" default:"24h"`
+	DeletedRetention time.Duration `env:"DELETED_RETENTION" default:"720h"`
+
+	// DefaultRedirectStatus is used for redirections without a status.
+	DefaultRedirectStatus int `env:"DEFAULT_REDIRECT_STATUS" default:"302"`
+
+	// RequireIfMatch rejects changes of redirections without an If-Match
+	// header, otherwise the header is only checked when it's given.
+	RequireIfMatch bool `env:"REQUIRE_IF_MATCH" default:"false"`
+
+	// ImportMaxRows and ImportMaxBytes limit the size of a single import.
+	ImportMaxRows  int `env:"IMPORT_MAX_ROWS" default:"10000"`
+	ImportMaxBytes int `env:"IMPORT_MAX_BYTES" default:"10485760"`
+
+	// IdempotencyKeyTTL is how long the response to a request with an
+	// Idempotency-Key header is replayed to retries, Sweep purges older ones.
+	// A key of a request that never completed, e.g. because the service
+	// crashed, can be used again after IdempotencyKeyLease.
+	IdempotencyKeyTTL   time.Duration `env:"IDEMPOTENCY_KEY_TTL" default:"24h"`
+	IdempotencyKeyLease time.Duration `env:"IDEMPOTENCY_KEY_LEASE" default:"1m"`
+
+	// RedirectCacheSize is the number of redirect lookups cached in memory,
+	// zero disables the cache. Found redirections are cached for
+	// RedirectCacheTTL and keys without one for RedirectCacheNegativeTTL.
+	RedirectCacheSize        int           `env:"REDIRECT_CACHE_SIZE" default:"10000"`
+	RedirectCacheTTL         time.Duration `env:"REDIRECT_CACHE_TTL" default:"1m"`
+	RedirectCacheNegativeTTL time.Duration `env:"REDIRECT_CACHE_NEGATIVE_TTL" default:"10s"`
+
+	// NamespaceDomain, when set, resolves redirects on its subdomains to the
+	// namespace of the subdomain, e.g. team.<NamespaceDomain>/key.
+	NamespaceDomain string `env:"NAMESPACE_DOMAIN"`
+	// NamespaceQuota is the maximum number of redirections of namespaces
+	// without their own quota, zero is unlimited.
+	NamespaceQuota int `env:"NAMESPACE_QUOTA" default:"0"`
+	// DefaultDomain is the domain used for redirects on hosts that aren't a
+	// known domain.
+	DefaultDomain string `env:"DEFAULT_DOMAIN"`
+
+	// JWKSURL, when set, also authenticates JWTs signed by the keys of this
+	// JSON Web Key Set, a http(s) URL or path to a file. The keys are reloaded
+	// every JWKSRefreshInterval. When JWTIssuer or JWTAudience are set the iss
+	// and aud claims of tokens have to match.
+	JWKSURL             string        `env:"JWKS_URL"`
+	JWKSRefreshInterval time.Duration `env:"JWKS_REFRESH_INTERVAL" default:"1h"`
+	JWTIssuer           string        `env:"JWT_ISSUER"`
+	JWTAudience         string        `env:"JWT_AUDIENCE"`
+	// JWTRoleClaim is the claim with the role, or list of roles, of a JWT.
+	JWTRoleClaim string `env:"JWT_ROLE_CLAIM" default:"roles"`
+	// JWTNamespaceClaim is the claim with the namespace, or list of
+	// namespaces, the caller of a JWT can use.
+	JWTNamespaceClaim string `env:"JWT_NAMESPACE_CLAIM" default:"namespaces"`
 }
 
 type Dependencies struct {
-	DB *sql.DB
+	Config *Config
+	DB     *sql.DB
+	Hits   *hits.Recorder
+	Rules  *rules.Matcher
+
+	// Auth authenticates the requests to routes that aren't public.
+	Auth             auth.Authenticator
+	APIKeys          *auth.APIKeys
+	public           map[string]bool
+	roles            map[string]auth.Role
+	idempotentRoutes map[string]bool
+	metrics          map[string]func() any
+
+	// background tracks the goroutines that use the database, it's closed
+	// after they've stopped.
+	background sync.WaitGroup
+
+	// sweepers are run by Sweep, which already runs in the background while
+	// routes add them.
+	sweepMu  sync.Mutex
+	sweepers []func(ctx context.Context) error
+
+	// domainHooks are added while setting up the routes, before any request
+	// changes a domain.
+	domainHooks []func(host string)
+}
+
+// OnSweep adds fn to be run by every Sweep, e.g. to purge the data of a route
+// that is no longer needed.
+func (d *Dependencies) OnSweep(fn func(ctx context.Context) error) {
+	d.sweepMu.Lock()
+	defer d.sweepMu.Unlock()
+	d.sweepers = append(d.sweepers, fn)
+}
+
+// OnDomainChange adds fn to be called with the host of every domain that is
+// created, changed or deleted, e.g. to forget it in a cache of another route.
+func (d *Dependencies) OnDomainChange(fn func(host string)) {
+	d.domainHooks = append(d.domainHooks, fn)
+}
+
+// DomainChanged calls the OnDomainChange hooks for the host, it should be
+// called after the domain of the host is changed.
+func (d *Dependencies) DomainChanged(host string) {
+	for _, fn := range d.domainHooks {
+		fn(host)
+	}
+}
+
+// Metric adds the counters returned by stats to the metrics, e.g. of a cache
+// of a route.
+func (d *Dependencies) Metric(component string, stats func() any) {
+	d.metrics[component] = stats
+}
+
+// Metrics returns the counters of the dependencies, keyed by component.
+func (d *Dependencies) Metrics() map[string]any {
+	metrics := map[string]any{
+		"hits": d.Hits.Stats(),
+	}
+	for component, stats := range d.metrics {
+		metrics[component] = stats()
+	}
+	return metrics
 }
 
 func DefaultConfig() *Config {
@@ -24,7 +189,9 @@ func DefaultConfig() *Config {
 	for i := 0; i < t.Type().NumField(); i++ {
 		f := t.Type().Field(i)
 		if def, ok := f.Tag.Lookup("default"); ok {
-			t.Field(i).SetString(def)
+			if err := setField(t.Field(i), def); err != nil {
+				panic(fmt.Sprintf("invalid default for %s: %v", f.Name, err))
+			}
 		}
 	}
 	return cfg
@@ -32,24 +199,150 @@ func DefaultConfig() *Config {
 
 func ConfigFromEnv(ctx context.Context) (*Config, error) {
 	cfg := DefaultConfig()
-	if v, ok := os.LookupEnv("DSN"); ok {
-		cfg.DSN = v
+	t := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < t.Type().NumField(); i++ {
+		f := t.Type().Field(i)
+		name, ok := f.Tag.Lookup("env")
+		if !ok {
+			continue
+		}
+		if v, ok := os.LookupEnv(name); ok {
+			if err := setField(t.Field(i), v); err != nil {
+				return nil, fmt.Errorf("invalid value for %s: %w", name, err)
+			}
+		}
 	}
 	return cfg, nil
 }
 
+// Set changes the field with the given env name to the parsed value.
+func (c *Config) Set(name, value string) error {
+	t := reflect.ValueOf(c).Elem()
+	for i := 0; i < t.Type().NumField(); i++ {
+		if env, ok := t.Type().Field(i).Tag.Lookup("env"); ok && env == name {
+			return setField(t.Field(i), value)
+		}
+	}
+	return fmt.Errorf("unknown config %s", name)
+}
+
+// setField parses the given string into the config field.
+func setField(field reflect.Value, value string) error {
+	switch field.Interface().(type) {
+	case string:
+		field.SetString(value)
+	case int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(n))
+	case bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+	case []string:
+		var values []string
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+		field.Set(reflect.ValueOf(values))
+	default:
+		return fmt.Errorf("unsupported config type %s", field.Type())
+	}
+	return nil
+}
+
+// withTimeFormat adds _time_format=sqlite to the DSN unless it has a time
+// format. Without it timestamps are written like time.Time.String, which the
+// datetime() of the queries can't parse.
+func withTimeFormat(dsn string) string {
+	_, query, hasQuery := strings.Cut(dsn, "?")
+	if values, err := url.ParseQuery(query); err == nil && values.Has("_time_format") {
+		return dsn
+	}
+	if hasQuery {
+		return dsn + "&_time_format=sqlite"
+	}
+	return dsn + "?_time_format=sqlite"
+}
+
 func Setup(ctx context.Context, config *Config) (*Dependencies, error) {
 	var err error
-	deps := &Dependencies{}
+	deps := &Dependencies{
+		Config:           config,
+		public:           map[string]bool{},
+		roles:            map[string]auth.Role{},
+		idempotentRoutes: map[string]bool{},
+		metrics:          map[string]func() any{},
+	}
 
-	if deps.DB, err = sql.Open("sqlite", config.DSN); err != nil {
+	if deps.DB, err = sql.Open("sqlite", withTimeFormat(config.DSN)); err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
+	if strings.HasPrefix(config.DSN, ":memory:") {
+		// Every connection to :memory: is a new empty database, so all queries
+		// have to share the same connection.
+		deps.DB.SetMaxOpenConns(1)
+	}
 	if err := migrations.Up(ctx, deps.DB); err != nil {
 		return nil, fmt.Errorf("failed to migrate database: %w", err)
 	}
+
+	deps.APIKeys = auth.NewAPIKeys(deps.DB)
+	deps.Auth = deps.APIKeys
+	if config.JWKSURL != "" {
+		keys := auth.NewKeySet(config.JWKSURL)
+		if err := keys.Load(ctx); err != nil {
+			return nil, fmt.Errorf("failed to load jwks: %w", err)
+		}
+		jwt := auth.NewJWT(keys, config.JWTIssuer, config.JWTAudience)
+		jwt.RoleClaim = config.JWTRoleClaim
+		jwt.NamespaceClaim = config.JWTNamespaceClaim
+		deps.Auth = auth.Chain{deps.APIKeys, jwt}
+
+		go every(ctx, config.JWKSRefreshInterval, func(ctx context.Context) {
+			if err := keys.Load(ctx); err != nil {
+				logging.GetLogger(ctx).Error("failed to reload jwks", "err", err)
+			}
+		})
+	}
+
+	deps.Rules = rules.NewMatcher(deps.DB)
+	if err := deps.Rules.Load(ctx); err != nil {
+		return nil, fmt.Errorf("failed to load rules: %w", err)
+	}
+
+	if config.HitFlushInterval <= 0 {
+		return nil, fmt.Errorf("invalid hit flush interval %s", config.HitFlushInterval)
+	}
+	deps.Hits = hits.NewRecorder(deps.DB, config.HitBufferSize, config.HitBatchSize, config.HitFlushInterval)
+	deps.Hits.Start(ctx)
+
+	deps.background.Add(1)
+	go func() {
+		defer deps.background.Done()
+		every(ctx, config.SweepInterval, func(ctx context.Context) {
+			if err := Sweep(ctx, deps); err != nil {
+				logging.GetLogger(ctx).Error("failed to sweep", "err", err)
+			}
+		})
+	}()
+
 	go func() {
 		<-ctx.Done()
+		<-deps.Hits.Done()
+		deps.background.Wait()
 		deps.DB.Close()
 	}()
 
@@ -0,0 +1,49 @@
+package hits
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/util/timeutil"
+)
+
+// Hit is a single resolution of a redirection.
+type Hit struct {
+	Namespace string
+	Key       string
+	CreatedAt time.Time
+	Referrer  string
+	UserAgent string
+	IP        string
+}
+
+// New creates a Hit from the redirected request, the client IP is anonymized
+// before it's stored.
+func New(r *http.Request, namespace, key string) *Hit {
+	return &Hit{
+		Namespace: namespace,
+		Key:       key,
+		CreatedAt: timeutil.Now(r.Context()),
+		Referrer:  r.Referer(),
+		UserAgent: r.UserAgent(),
+		IP:        anonymizeIP(r.RemoteAddr),
+	}
+}
+
+// anonymizeIP zeroes the host part of the address, the last octet of IPv4
+// addresses and the last 80 bits of IPv6 addresses.
+func anonymizeIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return ""
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String()
+}
@@ -0,0 +1,184 @@
+// hits records every resolution of a redirection. Recording happens in the
+// background so redirects never wait on a database write.
+package hits
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/koenbollen/logging"
+)
+
+// Stats are the counters of a Recorder.
+type Stats struct {
+	Recorded int64 `json:"recorded"`
+	Flushed  int64 `json:"flushed"`
+	Dropped  int64 `json:"dropped"`
+	Failed   int64 `json:"failed"`
+	Pending  int   `json:"pending"`
+}
+
+// Recorder buffers hits in memory and writes them to the database in batches,
+// either when a batch is full or every interval.
+type Recorder struct {
+	db        *sql.DB
+	batchSize int
+	interval  time.Duration
+
+	events  chan *Hit
+	flushes chan chan struct{}
+	done    chan struct{}
+
+	recorded atomic.Int64
+	flushed  atomic.Int64
+	dropped  atomic.Int64
+	failed   atomic.Int64
+}
+
+// NewRecorder creates a Recorder that buffers up to bufferSize hits, hits are
+// dropped when the buffer is full. Call Start to begin writing.
+func NewRecorder(db *sql.DB, bufferSize, batchSize int, interval time.Duration) *Recorder {
+	return &Recorder{
+		db:        db,
+		batchSize: max(batchSize, 1),
+		interval:  interval,
+		events:    make(chan *Hit, bufferSize),
+		flushes:   make(chan chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Start writes recorded hits in the background until the context is
+// cancelled, after which the remaining buffered hits are written.
+func (r *Recorder) Start(ctx context.Context) {
+	go r.run(ctx)
+}
+
+// Done is closed when the recorder has stopped and all buffered hits have
+// been written.
+func (r *Recorder) Done() <-chan struct{} {
+	return r.done
+}
+
+// Record buffers the hit without blocking, it reports false if the hit was
+// dropped because the buffer is full.
+func (r *Recorder) Record(hit *Hit) bool {
+	select {
+	case r.events <- hit:
+		r.recorded.Add(1)
+		return true
+	default:
+		r.dropped.Add(1)
+		return false
+	}
+}
+
+// Flush writes all buffered hits and waits until that's done.
+func (r *Recorder) Flush(ctx context.Context) error {
+	reply := make(chan struct{})
+	select {
+	case r.flushes <- reply:
+	case <-r.done:
+		return fmt.Errorf("recorder is stopped")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-reply:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stats returns the current counters of the recorder.
+func (r *Recorder) Stats() Stats {
+	return Stats{
+		Recorded: r.recorded.Load(),
+		Flushed:  r.flushed.Load(),
+		Dropped:  r.dropped.Load(),
+		Failed:   r.failed.Load(),
+		Pending:  len(r.events),
+	}
+}
+
+func (r *Recorder) run(ctx context.Context) {
+	defer close(r.done)
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	batch := make([]*Hit, 0, r.batchSize)
+	for {
+		select {
+		case hit := <-r.events:
+			batch = append(batch, hit)
+			if len(batch) >= r.batchSize {
+				r.write(ctx, batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			r.write(ctx, batch)
+			batch = batch[:0]
+		case reply := <-r.flushes:
+			r.drain(ctx, batch)
+			batch = batch[:0]
+			close(reply)
+		case <-ctx.Done():
+			r.drain(context.WithoutCancel(ctx), batch)
+			return
+		}
+	}
+}
+
+// drain writes the given batch and everything that's still buffered.
+func (r *Recorder) drain(ctx context.Context, batch []*Hit) {
+	for {
+		select {
+		case hit := <-r.events:
+			batch = append(batch, hit)
+			if len(batch) >= r.batchSize {
+				r.write(ctx, batch)
+				batch = batch[:0]
+			}
+		default:
+			r.write(ctx, batch)
+			return
+		}
+	}
+}
+
+// write inserts the batch in a single transaction.
+func (r *Recorder) write(ctx context.Context, batch []*Hit) {
+	if len(batch) == 0 {
+		return
+	}
+	if err := r.insert(ctx, batch); err != nil {
+		logging.GetLogger(ctx).Error("failed to write hits", "err", err, "count", len(batch))
+		r.failed.Add(int64(len(batch)))
+		return
+	}
+	r.flushed.Add(int64(len(batch)))
+}
+
+func (r *Recorder) insert(ctx context.Context, batch []*Hit) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() //nolint:errcheck
+
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO hit (namespace, key, created_at, referrer, user_agent, ip) VALUES (?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, hit := range batch {
+		if _, err := stmt.ExecContext(ctx, hit.Namespace, hit.Key, hit.CreatedAt, hit.Referrer, hit.UserAgent, hit.IP); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,215 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/auth"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/util/httputil"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/util/sqlutil"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/util/timeutil"
+	"github.com/koenbollen/logging"
+)
+
+// maxIdempotencyKeyLength is the longest Idempotency-Key header accepted.
+const maxIdempotencyKeyLength = 255
+
+// maxIdempotentBody is the largest request body of a request with an
+// Idempotency-Key, the body is read in memory to fingerprint the request.
+const maxIdempotentBody = 1 << 20
+
+// replayedHeaders are the response headers stored with an idempotency key,
+// other headers (e.g. the request id) belong to the request that is replayed.
+var replayedHeaders = []string{"Content-Type", "Location", "ETag"}
+
+// idempotentMethods are the methods that honour the Idempotency-Key header.
+var idempotentMethods = map[string]bool{
+	http.MethodPost:   true,
+	http.MethodPut:    true,
+	http.MethodPatch:  true,
+	http.MethodDelete: true,
+}
+
+// idempotent makes mutating requests with an Idempotency-Key header to the
+// routes of the mux marked with Idempotent safe to retry: the response is
+// stored with the key of the principal and replayed to retries of the same
+// request within the IdempotencyKeyTTL. Reusing a key for a different request
+// fails. Server errors aren't stored, so the request can be retried.
+func (d *Dependencies) idempotent(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := logging.GetLogger(ctx)
+		key := r.Header.Get("Idempotency-Key")
+		principal := auth.GetPrincipal(ctx)
+		_, pattern := mux.Handler(r)
+		if key == "" || principal == nil || !idempotentMethods[r.Method] || !d.idempotentRoutes[pattern] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			httputil.Error(w, r, http.StatusBadRequest, "the Idempotency-Key header is too long")
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBody))
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			httputil.Error(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("the request body must be at most %d bytes", maxBytesErr.Limit))
+			return
+		}
+		if err != nil {
+			httputil.Error(w, r, http.StatusBadRequest, "failed to read the request body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		fingerprint := requestFingerprint(r, body)
+
+		stored, err := d.claimIdempotencyKey(ctx, principal.ID(), key, fingerprint)
+		if err != nil {
+			logger.Error("failed to claim idempotency key", "err", err)
+			httputil.InternalError(w, r)
+			return
+		}
+		if stored != nil {
+			switch {
+			case stored.fingerprint != fingerprint:
+				httputil.Error(w, r, http.StatusUnprocessableEntity, "the Idempotency-Key is already used for a different request")
+			case !stored.status.Valid:
+				httputil.Error(w, r, http.StatusConflict, "a request with this Idempotency-Key is in progress")
+			default:
+				logger.Info("replaying idempotent request", "idempotency_key", key)
+				stored.replay(w)
+			}
+			return
+		}
+
+		// The key is released when the response isn't stored, also when the
+		// handler panics, and the response is stored even after the client
+		// went away.
+		ctx = context.WithoutCancel(ctx)
+		completed := false
+		defer func() {
+			if completed {
+				return
+			}
+			if _, err := d.DB.ExecContext(ctx, "DELETE FROM idempotency_key WHERE subject = ? AND key = ?", principal.ID(), key); err != nil {
+				logger.Error("failed to release idempotency key", "err", err)
+			}
+		}()
+		recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		if recorder.status >= http.StatusInternalServerError {
+			return
+		}
+
+		header := map[string]string{}
+		for _, name := range replayedHeaders {
+			if v := recorder.Header().Get(name); v != "" {
+				header[name] = v
+			}
+		}
+		raw, _ := json.Marshal(header)
+		_, err = d.DB.ExecContext(ctx, "UPDATE idempotency_key SET status = ?, header = ?, body = ? WHERE subject = ? AND key = ?",
+			recorder.status, string(raw), recorder.body.Bytes(), principal.ID(), key)
+		if err != nil {
+			logger.Error("failed to store idempotent response", "err", err)
+			return
+		}
+		completed = true
+	})
+}
+
+// requestFingerprint identifies a request by its method, URL, namespace,
+// preconditions and body.
+func requestFingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	parts := []string{r.Method, r.URL.RequestURI(), r.Header.Get("X-Namespace"), r.Header.Get("If-Match"), r.Header.Get("If-None-Match")}
+	for _, part := range parts {
+		io.WriteString(h, part) //nolint:errcheck
+		h.Write([]byte{0})
+	}
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// storedResponse is the row of an idempotency key, the status is NULL while
+// the request is in progress.
+type storedResponse struct {
+	fingerprint string
+	status      sql.NullInt64
+	header      sql.NullString
+	body        []byte
+}
+
+func (s *storedResponse) replay(w http.ResponseWriter) {
+	header := map[string]string{}
+	if s.header.Valid {
+		json.Unmarshal([]byte(s.header.String), &header) //nolint:errcheck
+	}
+	for name, value := range header {
+		w.Header().Set(name, value)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(int(s.status.Int64))
+	w.Write(s.body) //nolint:errcheck
+}
+
+// claimIdempotencyKey stores the key as in progress and returns nil, or
+// returns the stored response when the key is already used within the
+// IdempotencyKeyTTL. A key that is in progress for longer than the
+// IdempotencyKeyLease is claimed again.
+func (d *Dependencies) claimIdempotencyKey(ctx context.Context, subject, key, fingerprint string) (*storedResponse, error) {
+	now := timeutil.Now(ctx)
+	cutoff := now.Add(-d.Config.IdempotencyKeyTTL).UTC().Format(time.DateTime)
+	leaseCutoff := now.Add(-d.Config.IdempotencyKeyLease).UTC().Format(time.DateTime)
+	var stored *storedResponse
+	err := sqlutil.InTx(ctx, d.DB, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `
+			DELETE FROM idempotency_key
+			WHERE subject = ? AND key = ?
+			AND (datetime(created_at) <= datetime(?) OR (status IS NULL AND datetime(created_at) <= datetime(?)))
+		`, subject, key, cutoff, leaseCutoff)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "INSERT INTO idempotency_key (subject, key, fingerprint, created_at) VALUES (?, ?, ?, ?)", subject, key, fingerprint, now)
+		if !sqlutil.IsUniqueViolation(err) {
+			return err
+		}
+		stored = &storedResponse{}
+		return tx.QueryRowContext(ctx, "SELECT fingerprint, status, header, body FROM idempotency_key WHERE subject = ? AND key = ?", subject, key).
+			Scan(&stored.fingerprint, &stored.status, &stored.header, &stored.body)
+	})
+	return stored, err
+}
+
+// responseRecorder passes the response through while recording its status
+// and body, the status is 200 OK unless the handler writes another.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status, rec.wroteHeader = status, true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
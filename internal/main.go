@@ -7,9 +7,12 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/auth"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/util/httputil"
 	"github.com/koenbollen/logging"
 
 	_ "modernc.org/sqlite"
@@ -19,24 +22,68 @@ import (
 type Route func(context.Context, *http.ServeMux, *Dependencies) error
 
 // SetupRoutes will combine all the routes into a simple http.ServeMux and
-// add a health check route
+// add a health check, metrics and whoami route. Requests that don't match any
+// route get a 404 problem response, or a 405 when the path has routes for
+// other methods. Only the routes marked with deps.Public can be requested
+// without authentication, routes without a role set with deps.Require are for
+// admins only. Changes of routes marked with deps.Idempotent can be retried
+// with an Idempotency-Key.
 func SetupRoutes(ctx context.Context, deps *Dependencies, routes ...Route) (*http.ServeMux, error) {
 	mux := http.NewServeMux()
+	mux.HandleFunc("/", unmatched(mux))
+	deps.Public("/", "GET /health")
+	deps.Require(auth.RoleViewer, "GET /metrics")
+	deps.Require("", "GET /whoami")
 	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
 		logging.IgnoreRequest(r)
 		if deps.DB.PingContext(r.Context()) != nil {
-			w.WriteHeader(http.StatusServiceUnavailable)
+			httputil.Error(w, r, http.StatusServiceUnavailable, "database unavailable")
 			return
 		}
 		w.WriteHeader(http.StatusOK)
 	})
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		logging.IgnoreRequest(r)
+		httputil.WriteJSON(w, http.StatusOK, deps.Metrics())
+	})
+	mux.HandleFunc("GET /whoami", func(w http.ResponseWriter, r *http.Request) {
+		httputil.WriteJSON(w, http.StatusOK, auth.GetPrincipal(r.Context()))
+	})
 
 	for _, route := range routes {
 		if err := route(ctx, mux, deps); err != nil {
 			return nil, fmt.Errorf("failed to add route: %w", err)
 		}
 	}
-	return mux, nil
+
+	root := http.NewServeMux()
+	root.Handle("/", deps.authenticate(mux, deps.idempotent(mux, mux)))
+	return root, nil
+}
+
+// routeMethods are the methods that are checked to find the allowed methods
+// of a path.
+var routeMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+// unmatched handles the requests that don't match any route of the mux. The
+// catch-all route matches every method, so unlike the mux itself this
+// responds with 405 and the Allow header when only the method is wrong.
+func unmatched(mux *http.ServeMux) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var allowed []string
+		for _, method := range routeMethods {
+			probe := &http.Request{Method: method, Host: r.Host, URL: r.URL}
+			if _, pattern := mux.Handler(probe); pattern != "" && pattern != "/" {
+				allowed = append(allowed, method)
+			}
+		}
+		if len(allowed) == 0 {
+			httputil.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		httputil.Error(w, r, http.StatusMethodNotAllowed, "")
+	}
 }
 
 // Main will handle the setup of dependencies, routes and the http server. Start
@@ -56,13 +103,18 @@ func Main(ctx context.Context, component string, routes ...Route) {
 		return
 	}
 
-	deps, err := Setup(ctx, config)
+	// The dependencies and requests outlive ctx, they're stopped after the
+	// server has finished the requests in flight.
+	depsCtx, stopDeps := context.WithCancel(context.WithoutCancel(ctx))
+	defer stopDeps()
+
+	deps, err := Setup(depsCtx, config)
 	if err != nil {
 		logger.Error("failed to setup dependencies", "err", err)
 		return
 	}
 
-	mux, err := SetupRoutes(ctx, deps, routes...)
+	mux, err := SetupRoutes(depsCtx, deps, routes...)
 	if err != nil {
 		logger.Error("failed to setup routes", "err", err)
 		return
@@ -73,7 +125,7 @@ func Main(ctx context.Context, component string, routes ...Route) {
 		Handler: logging.Middleware(mux, logger),
 
 		BaseContext: func(net.Listener) context.Context {
-			return ctx
+			return depsCtx
 		},
 
 		// Better timeouts for load balancers:
@@ -98,6 +150,13 @@ func Main(ctx context.Context, component string, routes ...Route) {
 	defer cancel()
 	if err := server.Shutdown(ctx); err != nil {
 		logger.Error("failed to shutdown server", "err", err)
-		return
+	}
+	stopDeps()
+
+	select {
+	case <-deps.Hits.Done():
+		logger.Info("flushed hits", "stats", deps.Hits.Stats())
+	case <-ctx.Done():
+		logger.Error("failed to flush hits", "err", ctx.Err())
 	}
 }
@@ -0,0 +1,75 @@
+package internal
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/auth"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/util/httputil"
+	"github.com/koenbollen/logging"
+)
+
+// Public marks the routes with the given patterns as public, these can be
+// requested without authentication. All other routes require it.
+func (d *Dependencies) Public(patterns ...string) {
+	for _, pattern := range patterns {
+		d.public[pattern] = true
+	}
+}
+
+// Require sets the role needed for the routes with the given patterns. Routes
+// that aren't public and have no required role are for admins only.
+func (d *Dependencies) Require(role auth.Role, patterns ...string) {
+	for _, pattern := range patterns {
+		d.roles[pattern] = role
+	}
+}
+
+// Idempotent marks the routes with the given patterns as safe to retry with an
+// Idempotency-Key header, their responses are stored. Routes with responses
+// that shouldn't be stored, e.g. secrets, or with large bodies aren't marked.
+func (d *Dependencies) Idempotent(patterns ...string) {
+	for _, pattern := range patterns {
+		d.idempotentRoutes[pattern] = true
+	}
+}
+
+// authenticate requires requests to routes of the mux that aren't public to be
+// authenticated by a principal with the role of the route, the principal is
+// added to the request context of next.
+func (d *Dependencies) authenticate(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		_, pattern := mux.Handler(r)
+		if pattern == "" || d.public[pattern] {
+			mux.ServeHTTP(w, r)
+			return
+		}
+
+		token, err := auth.BearerToken(r)
+		var principal *auth.Principal
+		if err == nil {
+			principal, err = d.Auth.Authenticate(ctx, token)
+		}
+		if err != nil {
+			if !errors.Is(err, auth.ErrNoCredentials) && !errors.Is(err, auth.ErrInvalidCredentials) {
+				logging.GetLogger(ctx).Error("failed to authenticate", "err", err)
+				httputil.InternalError(w, r)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			httputil.Error(w, r, http.StatusUnauthorized, err.Error())
+			return
+		}
+
+		required, ok := d.roles[pattern]
+		if !ok {
+			required = auth.RoleAdmin
+		}
+		if !principal.Role.Includes(required) {
+			httputil.Error(w, r, http.StatusForbidden, "this requires the "+string(required)+" role")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(ctx, principal)))
+	})
+}
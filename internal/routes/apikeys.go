@@ -0,0 +1,121 @@
+package routes
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/auth"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/util/httputil"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/util/sqlutil"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/util/timeutil"
+	"github.com/koenbollen/logging"
+)
+
+// APIKeyRequest is used to issue (POST) an API key with a role. Keys that
+// aren't admin can only use the given namespaces, or the default namespace
+// when there are none.
+type APIKeyRequest struct {
+	Name       string   `json:"name"`
+	Role       string   `json:"role"`
+	Namespaces []string `json:"namespaces"`
+}
+
+// IssuedAPIKey is an API key as returned when it's issued, the only time the
+// key itself is returned.
+type IssuedAPIKey struct {
+	*auth.APIKey
+	Key string `json:"key"`
+}
+
+// APIKeyListResponse are all API keys, without the keys themselves.
+type APIKeyListResponse struct {
+	Items []*auth.APIKey `json:"items"`
+}
+
+// APIKeys allows clients to issue, list and revoke API keys. The first key
+// has to be issued with the apikeys command.
+func APIKeys(ctx context.Context, mux *http.ServeMux, deps *internal.Dependencies) error {
+	mux.HandleFunc("POST /api-keys", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := logging.GetLogger(ctx)
+		request := &APIKeyRequest{}
+		if !httputil.DecodeJSON(w, r, request) {
+			return
+		}
+		problem := httputil.NewProblem(http.StatusBadRequest, "the api key is invalid")
+		if request.Name == "" {
+			problem.WithFieldError("name", "is required")
+		}
+		role, ok := auth.ParseRole(request.Role)
+		if !ok {
+			problem.WithFieldError("role", "must be viewer, editor or admin")
+		}
+		for i, name := range request.Namespaces {
+			if !namespaceName.MatchString(name) {
+				problem.WithFieldError(fmt.Sprintf("namespaces.%d", i), "is not a valid namespace")
+			}
+		}
+		if problem.HasErrors() {
+			httputil.WriteProblem(w, r, problem)
+			return
+		}
+
+		key, apiKey, err := deps.APIKeys.Issue(ctx, request.Name, role, request.Namespaces, timeutil.Now(ctx))
+		if sqlutil.IsUniqueViolation(err) {
+			httputil.WriteProblem(w, r, httputil.NewProblem(http.StatusConflict, "an api key with this name already exists").WithFieldError("name", "already exists"))
+			return
+		}
+		if err != nil {
+			logger.Error("failed to issue api key", "err", err)
+			httputil.InternalError(w, r)
+			return
+		}
+		w.Header().Set("Location", fmt.Sprintf("/api-keys/%d", apiKey.ID))
+		httputil.WriteJSON(w, http.StatusCreated, &IssuedAPIKey{APIKey: apiKey, Key: key})
+
+		logger.Info("issued api key", "id", apiKey.ID, "name", apiKey.Name, "role", apiKey.Role, "namespaces", apiKey.Namespaces)
+	})
+
+	mux.HandleFunc("GET /api-keys", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		keys, err := deps.APIKeys.List(ctx)
+		if err != nil {
+			logging.GetLogger(ctx).Error("failed to list api keys", "err", err)
+			httputil.InternalError(w, r)
+			return
+		}
+		httputil.WriteJSON(w, http.StatusOK, &APIKeyListResponse{Items: keys})
+	})
+
+	mux.HandleFunc("DELETE /api-keys/{id}", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := logging.GetLogger(ctx)
+
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			httputil.Error(w, r, http.StatusNotFound, "api key not found")
+			return
+		}
+		revoked, err := deps.APIKeys.Revoke(ctx, id, timeutil.Now(ctx))
+		if err != nil {
+			logger.Error("failed to revoke api key", "err", err)
+			httputil.InternalError(w, r)
+			return
+		}
+		if !revoked {
+			httputil.Error(w, r, http.StatusNotFound, "api key not found")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+		logger.Info("revoked api key", "id", id)
+	})
+
+	// These routes aren't marked Idempotent, the response of an issued key
+	// must never be stored.
+	deps.Require(auth.RoleAdmin, "POST /api-keys", "GET /api-keys", "DELETE /api-keys/{id}")
+	return nil
+}
@@ -0,0 +1,233 @@
+package routes
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/auth"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/util/httputil"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/util/sqlutil"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/util/timeutil"
+	"github.com/koenbollen/logging"
+)
+
+// The actions recorded in the audit log.
+const (
+	auditCreate  = "create"
+	auditUpdate  = "update"
+	auditDelete  = "delete"
+	auditRestore = "restore"
+	auditPurge   = "purge"
+)
+
+// AuditEntry is a single change of a redirection, with the redirection before
+// and after the change. Before is null for creates and After for deletes.
+type AuditEntry struct {
+	ID        int64           `json:"id"`
+	Namespace string          `json:"namespace"`
+	Key       string          `json:"key"`
+	Action    string          `json:"action"`
+	Actor     string          `json:"actor,omitempty"`
+	Before    json.RawMessage `json:"before"`
+	After     json.RawMessage `json:"after"`
+	RequestID string          `json:"request_id,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// AuditListResponse is a page of the audit log, newest first.
+type AuditListResponse struct {
+	Items      []*AuditEntry `json:"items"`
+	Limit      int           `json:"limit"`
+	HasMore    bool          `json:"has_more"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+// recordAudit appends the change of a redirection to the audit log, it should
+// be called in the transaction of the change. The actor and request id are
+// taken from the context.
+func recordAudit(ctx context.Context, tx sqlutil.Querier, action string, before, after *Redirection) error {
+	subject := after
+	if subject == nil {
+		subject = before
+	}
+	var actor string
+	if principal := auth.GetPrincipal(ctx); principal != nil {
+		actor = principal.ID()
+	}
+	beforeJSON, err := auditJSON(before)
+	if err != nil {
+		return err
+	}
+	afterJSON, err := auditJSON(after)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO audit (namespace, key, action, actor, before, after, request_id, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, subject.Namespace, subject.Key, action, nullString(actor), beforeJSON, afterJSON,
+		nullString(logging.GetRequestID(ctx)), timeutil.Now(ctx))
+	return err
+}
+
+func auditJSON(redirection *Redirection) (sql.NullString, error) {
+	if redirection == nil {
+		return sql.NullString{}, nil
+	}
+	raw, err := json.Marshal(redirection)
+	return sql.NullString{String: string(raw), Valid: true}, err
+}
+
+// auditQuery is the parsed form of the query parameters of GET /audit.
+type auditQuery struct {
+	limit  int
+	cursor int64
+
+	// filters are the column = value conditions, e.g. key or actor.
+	filters map[string]string
+	ranges  []timeRange
+}
+
+func parseAuditQuery(q url.Values) (*auditQuery, error) {
+	query := &auditQuery{limit: defaultListLimit, filters: map[string]string{}}
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxListLimit {
+			return nil, fmt.Errorf("limit must be a number between 1 and %d", maxListLimit)
+		}
+		query.limit = limit
+	}
+	if v := q.Get("cursor"); v != "" {
+		c, err := decodeCursor(v)
+		if err == nil && c.Sort == "-id" {
+			query.cursor, err = strconv.ParseInt(c.Key, 10, 64)
+		}
+		if err != nil || query.cursor <= 0 {
+			return nil, fmt.Errorf("cursor is invalid")
+		}
+	}
+
+	for _, name := range []string{"namespace", "key", "actor", "action"} {
+		if v := q.Get(name); v != "" {
+			query.filters[name] = v
+		}
+	}
+	if v, ok := query.filters["action"]; ok && v != auditCreate && v != auditUpdate && v != auditDelete && v != auditRestore && v != auditPurge {
+		return nil, fmt.Errorf("action must be one of create, update, delete, restore or purge")
+	}
+
+	for _, param := range []struct {
+		name, op string
+	}{
+		{"created_after", ">="},
+		{"created_before", "<"},
+	} {
+		v := q.Get(param.name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be a RFC3339 timestamp", param.name)
+		}
+		query.ranges = append(query.ranges, timeRange{"created_at", param.op, t})
+	}
+	return query, nil
+}
+
+// sql builds the SELECT statement and its arguments for this query, it fetches
+// one row more than the limit to determine if there is a next page.
+func (query *auditQuery) sql() (string, []any) {
+	where := []string{"1 = 1"}
+	var args []any
+	for _, column := range []string{"namespace", "key", "actor", "action"} {
+		if v, ok := query.filters[column]; ok {
+			where = append(where, column+" = ?")
+			args = append(args, v)
+		}
+	}
+	for _, r := range query.ranges {
+		where = append(where, "datetime("+r.column+") "+r.op+" datetime(?)")
+		args = append(args, r.value.UTC().Format(time.DateTime))
+	}
+	if query.cursor > 0 {
+		where = append(where, "id < ?")
+		args = append(args, query.cursor)
+	}
+
+	q := "SELECT id, namespace, key, action, actor, before, after, request_id, created_at FROM audit"
+	q += " WHERE " + strings.Join(where, " AND ")
+	q += " ORDER BY id DESC LIMIT " + strconv.Itoa(query.limit+1)
+	return q, args
+}
+
+// Audit allows admins to read the audit log of all redirection changes.
+func Audit(ctx context.Context, mux *http.ServeMux, deps *internal.Dependencies) error {
+	db := deps.DB
+
+	mux.HandleFunc("GET /audit", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := logging.GetLogger(ctx)
+
+		query, err := parseAuditQuery(r.URL.Query())
+		if err != nil {
+			httputil.Error(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		q, args := query.sql()
+		rows, err := db.QueryContext(ctx, q, args...)
+		if err != nil {
+			logger.Error("failed to query audit log", "err", err)
+			httputil.InternalError(w, r)
+			return
+		}
+		defer rows.Close()
+
+		response := &AuditListResponse{Items: []*AuditEntry{}, Limit: query.limit}
+		for rows.Next() {
+			if len(response.Items) == query.limit {
+				response.HasMore = true
+				break
+			}
+			entry := &AuditEntry{}
+			var actor, before, after, requestID sql.NullString
+			if err := rows.Scan(&entry.ID, &entry.Namespace, &entry.Key, &entry.Action, &actor, &before, &after, &requestID, &entry.CreatedAt); err != nil {
+				logger.Error("failed to scan audit entry", "err", err)
+				httputil.InternalError(w, r)
+				return
+			}
+			entry.Actor, entry.RequestID = actor.String, requestID.String
+			if before.Valid {
+				entry.Before = json.RawMessage(before.String)
+			}
+			if after.Valid {
+				entry.After = json.RawMessage(after.String)
+			}
+			response.Items = append(response.Items, entry)
+		}
+		if err := rows.Err(); err != nil {
+			logger.Error("failed to query audit log", "err", err)
+			httputil.InternalError(w, r)
+			return
+		}
+
+		if response.HasMore {
+			last := response.Items[len(response.Items)-1]
+			response.NextCursor = (&cursor{Sort: "-id", Key: strconv.FormatInt(last.ID, 10)}).encode()
+		}
+		httputil.WriteJSON(w, http.StatusOK, response)
+	})
+
+	deps.Require(auth.RoleAdmin, "GET /audit")
+	return nil
+}
@@ -0,0 +1,311 @@
+package routes
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/auth"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/util/httputil"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/util/sqlutil"
+	"github.com/koenbollen/logging"
+)
+
+// maxBatchOperations is the maximum number of operations of a single batch.
+const maxBatchOperations = 100
+
+// The modes of a batch: atomic batches are rolled back when any operation
+// fails, best effort batches keep the operations that succeeded.
+const (
+	batchAtomic     = "atomic"
+	batchBestEffort = "best_effort"
+)
+
+// The operations of a batch.
+const (
+	batchCreate = "create"
+	batchUpdate = "update"
+	batchDelete = "delete"
+)
+
+// BatchRequest is a list of operations executed in a single transaction.
+type BatchRequest struct {
+	Mode       string            `json:"mode"`
+	Operations []*BatchOperation `json:"operations"`
+}
+
+// BatchOperation creates, updates or deletes a single redirection. The data
+// of a create is a CreateRequest and of an update a PatchRequest, updates and
+// deletes need the key of the redirection. IfMatch is checked like the
+// If-Match header of the routes.
+type BatchOperation struct {
+	Op      string          `json:"op"`
+	Key     string          `json:"key"`
+	Data    json.RawMessage `json:"data"`
+	IfMatch string          `json:"if_match"`
+}
+
+// BatchResult is the outcome of a single operation, with the status and
+// redirection or problem the route of the operation would respond with.
+type BatchResult struct {
+	Index       int               `json:"index"`
+	Op          string            `json:"op"`
+	Key         string            `json:"key,omitempty"`
+	Status      int               `json:"status"`
+	Redirection *Redirection      `json:"redirection,omitempty"`
+	Problem     *httputil.Problem `json:"problem,omitempty"`
+}
+
+// BatchResponse has the results of all operations of a batch.
+type BatchResponse struct {
+	Mode      string         `json:"mode"`
+	Committed bool           `json:"committed"`
+	Succeeded int            `json:"succeeded"`
+	Failed    int            `json:"failed"`
+	Results   []*BatchResult `json:"results"`
+}
+
+func (req *BatchRequest) validate() *httputil.Problem {
+	problem := httputil.NewProblem(http.StatusBadRequest, "the batch is invalid")
+	req.Mode = cmp.Or(req.Mode, batchAtomic)
+	if req.Mode != batchAtomic && req.Mode != batchBestEffort {
+		problem.WithFieldError("mode", "must be atomic or best_effort")
+	}
+	if n := len(req.Operations); n == 0 || n > maxBatchOperations {
+		problem.WithFieldError("operations", fmt.Sprintf("must have between 1 and %d operations", maxBatchOperations))
+	}
+	for i, op := range req.Operations {
+		field := fmt.Sprintf("operations.%d", i)
+		if op == nil {
+			problem.WithFieldError(field, "is required")
+			continue
+		}
+		switch op.Op {
+		case batchCreate:
+		case batchUpdate, batchDelete:
+			if op.Key == "" {
+				problem.WithFieldError(field+".key", "is required")
+			}
+		default:
+			problem.WithFieldError(field+".op", "must be one of create, update or delete")
+		}
+		if op.Op != batchDelete && len(op.Data) == 0 {
+			problem.WithFieldError(field+".data", "is required")
+		}
+	}
+	if problem.HasErrors() {
+		return problem
+	}
+	return nil
+}
+
+// batcher executes the operations of a single batch in its transaction.
+type batcher struct {
+	deps      *internal.Dependencies
+	keys      *keyPolicy
+	namespace string
+	principal *auth.Principal
+}
+
+// run executes a single operation, client errors are reported in the result
+// and only database errors are returned.
+func (b *batcher) run(ctx context.Context, tx *sql.Tx, index int, op *BatchOperation) (*BatchResult, error) {
+	result := &BatchResult{Index: index, Op: op.Op, Key: op.Key}
+	var problem *httputil.Problem
+	var err error
+	switch op.Op {
+	case batchCreate:
+		result.Status = http.StatusCreated
+		result.Redirection, problem, err = b.create(ctx, tx, op.Data)
+	case batchUpdate:
+		result.Status = http.StatusOK
+		result.Redirection, problem, err = b.update(ctx, tx, op)
+	case batchDelete:
+		result.Status = http.StatusNoContent
+		problem, err = b.delete(ctx, tx, op)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if problem != nil {
+		result.Status, result.Problem = problem.Status, problem
+	}
+	if result.Redirection != nil {
+		result.Key = result.Redirection.Key
+	}
+	return result, nil
+}
+
+func (b *batcher) create(ctx context.Context, tx *sql.Tx, data json.RawMessage) (*Redirection, *httputil.Problem, error) {
+	request := &CreateRequest{}
+	if err := json.Unmarshal(data, request); err != nil {
+		return nil, httputil.NewProblem(http.StatusBadRequest, "data must be valid JSON: "+err.Error()), nil
+	}
+	if problem := request.validate(ctx, b.deps.Config, b.keys); problem != nil {
+		return nil, problem, nil
+	}
+
+	redirection := &Redirection{
+		Namespace:       b.namespace,
+		Key:             request.Key,
+		URL:             request.URL,
+		Status:          request.Status,
+		ExpiresAt:       request.ExpiresAt,
+		ForwardPath:     request.ForwardPath,
+		ForwardQuery:    request.ForwardQuery,
+		QueryPrecedence: request.QueryPrecedence,
+		UTM:             request.UTM,
+		Owner:           b.principal.ID(),
+	}
+	var err error
+	if redirection.Key != "" {
+		err = insertRedirection(ctx, tx, b.deps.Config, redirection)
+	} else {
+		err = insertWithGeneratedKey(ctx, tx, b.keys, redirection)
+	}
+	if sqlutil.IsUniqueViolation(err) {
+		return nil, httputil.NewProblem(http.StatusConflict, "a redirection with this key already exists").WithFieldError("key", "already exists"), nil
+	}
+	if err == errQuotaExceeded {
+		return nil, httputil.NewProblem(http.StatusForbidden, "the quota of namespace "+b.namespace+" is exceeded"), nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if redirection, err = getRedirection(ctx, tx, b.namespace, redirection.Key); err != nil {
+		return nil, nil, err
+	}
+	return redirection, nil, recordAudit(ctx, tx, auditCreate, nil, redirection)
+}
+
+// precondition returns the problem of an operation without a required
+// if_match or with one that doesn't match the existing redirection.
+func (b *batcher) precondition(op *BatchOperation, existing *Redirection) *httputil.Problem {
+	if op.IfMatch == "" {
+		if b.deps.Config.RequireIfMatch {
+			return httputil.NewProblem(http.StatusPreconditionRequired, "if_match is required")
+		}
+		return nil
+	}
+	if existing == nil || !httputil.MatchETag(op.IfMatch, existing.etag(), false) {
+		return httputil.NewProblem(http.StatusPreconditionFailed, errChanged)
+	}
+	return nil
+}
+
+func (b *batcher) update(ctx context.Context, tx *sql.Tx, op *BatchOperation) (*Redirection, *httputil.Problem, error) {
+	request := &PatchRequest{}
+	if err := json.Unmarshal(op.Data, request); err != nil {
+		return nil, httputil.NewProblem(http.StatusBadRequest, "data must be valid JSON: "+err.Error()), nil
+	}
+	if problem := request.validate(ctx, b.deps.Config); problem != nil {
+		return nil, problem, nil
+	}
+
+	existing, err := getRedirection(ctx, tx, b.namespace, op.Key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if existing == nil {
+		return nil, httputil.NewProblem(http.StatusNotFound, "redirection not found"), nil
+	}
+	if !existing.manageableBy(b.principal) {
+		return nil, httputil.NewProblem(http.StatusForbidden, errNotOwner), nil
+	}
+	if problem := b.precondition(op, existing); problem != nil {
+		return nil, problem, nil
+	}
+	patched := *existing
+	request.apply(&patched)
+	redirection, err := updateRedirection(ctx, tx, &patched)
+	if err != nil {
+		return nil, nil, err
+	}
+	return redirection, nil, recordAudit(ctx, tx, auditUpdate, existing, redirection)
+}
+
+// delete deletes the redirection, like DELETE /redirections/{key} deleting a
+// redirection that doesn't exist succeeds without if_match.
+func (b *batcher) delete(ctx context.Context, tx *sql.Tx, op *BatchOperation) (*httputil.Problem, error) {
+	existing, err := getRedirection(ctx, tx, b.namespace, op.Key)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil && !existing.manageableBy(b.principal) {
+		return httputil.NewProblem(http.StatusForbidden, errNotOwner), nil
+	}
+	if problem := b.precondition(op, existing); problem != nil || existing == nil {
+		return problem, nil
+	}
+	if err := deleteRedirection(ctx, tx, b.namespace, op.Key); err != nil {
+		return nil, err
+	}
+	return nil, recordAudit(ctx, tx, auditDelete, existing, nil)
+}
+
+// batchRedirections executes the operations of a batch in order in a single
+// transaction. All operations are executed to report every failure, but an
+// atomic batch is rolled back when any of them failed.
+func batchRedirections(deps *internal.Dependencies, keys *keyPolicy, lookups *redirectCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := logging.GetLogger(ctx)
+		namespace, ok := requestNamespace(w, r)
+		if !ok {
+			return
+		}
+		request := &BatchRequest{}
+		if !httputil.DecodeJSON(w, r, request) {
+			return
+		}
+		if problem := request.validate(); problem != nil {
+			httputil.WriteProblem(w, r, problem)
+			return
+		}
+
+		b := &batcher{deps: deps, keys: keys, namespace: namespace, principal: auth.GetPrincipal(ctx)}
+		response := &BatchResponse{Mode: request.Mode, Results: []*BatchResult{}}
+		err := sqlutil.InTx(ctx, deps.DB, func(tx *sql.Tx) error {
+			for i, op := range request.Operations {
+				result, err := b.run(ctx, tx, i, op)
+				if err != nil {
+					return err
+				}
+				if result.Problem != nil {
+					response.Failed++
+				} else {
+					response.Succeeded++
+				}
+				response.Results = append(response.Results, result)
+			}
+			if request.Mode == batchAtomic && response.Failed > 0 {
+				return errRollback
+			}
+			return nil
+		})
+		if err != nil && err != errRollback {
+			logger.Error("failed to execute batch", "err", err)
+			httputil.InternalError(w, r)
+			return
+		}
+		response.Committed = err == nil
+		if response.Committed {
+			for _, result := range response.Results {
+				lookups.forget(namespace, result.Key)
+			}
+		}
+
+		status := http.StatusOK
+		if !response.Committed {
+			status = http.StatusUnprocessableEntity
+		}
+		httputil.WriteJSON(w, status, response)
+
+		logger.Info("executed batch", "namespace", namespace, "mode", request.Mode, "committed", response.Committed,
+			"succeeded", response.Succeeded, "failed", response.Failed)
+	}
+}
@@ -0,0 +1,553 @@
+package routes
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/auth"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/util/httputil"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/util/sqlutil"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/util/timeutil"
+	"github.com/koenbollen/logging"
+)
+
+// The formats of imports and exports, JSON Lines has a redirection as JSON
+// object per line and CSV a redirection per record after a header.
+const (
+	jsonLinesContentType = "application/x-ndjson"
+	csvContentType       = "text/csv"
+)
+
+// The conflict modes of an import decide what happens to a row with the key
+// of an existing redirection.
+const (
+	importFail   = "fail"
+	importSkip   = "skip"
+	importUpsert = "upsert"
+)
+
+// The results of a single import row.
+const (
+	rowCreated = "created"
+	rowUpdated = "updated"
+	rowSkipped = "skipped"
+	rowFailed  = "failed"
+)
+
+// maxImportLine is the longest line of a JSON Lines import.
+const maxImportLine = 1 << 20
+
+var (
+	// errRollback is returned from a transaction to roll it back on purpose.
+	errRollback = errors.New("rollback")
+	// errTooManyRows is returned when an import has more rows than allowed.
+	errTooManyRows = errors.New("too many rows")
+)
+
+// csvColumns are the columns of a CSV export, the UTM parameters each have
+// their own column.
+var csvColumns = slices.Concat(
+	[]string{"key", "url", "status", "expires_at", "forward_path", "forward_query", "query_precedence"},
+	utmColumns(),
+	[]string{"owner", "created_at", "updated_at"},
+)
+
+// csvReadOnlyColumns are exported but ignored by an import, so an export can
+// be imported as is.
+var csvReadOnlyColumns = []string{"namespace", "owner", "created_at", "updated_at", "deleted_at"}
+
+func utmColumns() []string {
+	columns := make([]string, len(utmNames))
+	for i, name := range utmNames {
+		columns[i] = "utm_" + name
+	}
+	return columns
+}
+
+// ImportRow is the result of a single row of an import, rows are numbered
+// from 1 without the CSV header and empty lines.
+type ImportRow struct {
+	Row    int                   `json:"row"`
+	Key    string                `json:"key,omitempty"`
+	Result string                `json:"result"`
+	Detail string                `json:"detail,omitempty"`
+	Errors []httputil.FieldError `json:"errors,omitempty"`
+}
+
+// ImportResponse has the results of all rows of an import. Nothing is
+// committed when it's a dry run or when any row failed.
+type ImportResponse struct {
+	DryRun    bool         `json:"dry_run"`
+	Mode      string       `json:"mode"`
+	Committed bool         `json:"committed"`
+	Created   int          `json:"created"`
+	Updated   int          `json:"updated"`
+	Skipped   int          `json:"skipped"`
+	Failed    int          `json:"failed"`
+	Rows      []*ImportRow `json:"rows"`
+}
+
+func (resp *ImportResponse) add(row *ImportRow) {
+	switch row.Result {
+	case rowCreated:
+		resp.Created++
+	case rowUpdated:
+		resp.Updated++
+	case rowSkipped:
+		resp.Skipped++
+	case rowFailed:
+		resp.Failed++
+	}
+	resp.Rows = append(resp.Rows, row)
+}
+
+// rowError is an error in a single row of an import, the row fails and the
+// import continues with the next one.
+type rowError struct {
+	msg string
+}
+
+func (e *rowError) Error() string {
+	return e.msg
+}
+
+// importReader reads the rows of an import one by one, next returns io.EOF
+// after the last row. Any error other than a *rowError aborts the import.
+type importReader interface {
+	next() (*CreateRequest, error)
+}
+
+// newImportReader returns the reader for the format of the request body, an
+// absent content type is read as JSON Lines.
+func newImportReader(r *http.Request) (importReader, *httputil.Problem) {
+	mediaType := jsonLinesContentType
+	if v := r.Header.Get("Content-Type"); v != "" {
+		mediaType, _, _ = mime.ParseMediaType(v)
+	}
+	switch mediaType {
+	case jsonLinesContentType, "application/jsonl":
+		scanner := bufio.NewScanner(r.Body)
+		scanner.Buffer(nil, maxImportLine)
+		return &jsonLinesReader{scanner: scanner}, nil
+	case csvContentType:
+		reader, err := newCSVReader(r.Body)
+		if err != nil {
+			return nil, httputil.NewProblem(http.StatusBadRequest, err.Error())
+		}
+		return reader, nil
+	}
+	return nil, httputil.NewProblem(http.StatusUnsupportedMediaType, "content type must be "+jsonLinesContentType+" or "+csvContentType)
+}
+
+type jsonLinesReader struct {
+	scanner *bufio.Scanner
+}
+
+func (r *jsonLinesReader) next() (*CreateRequest, error) {
+	for r.scanner.Scan() {
+		line := bytes.TrimSpace(r.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		request := &CreateRequest{}
+		if err := json.Unmarshal(line, request); err != nil {
+			return nil, &rowError{"row must be valid JSON: " + err.Error()}
+		}
+		return request, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+type csvReader struct {
+	reader *csv.Reader
+	header []string
+}
+
+// newCSVReader reads and checks the header of a CSV import, which can have
+// any of the csvColumns and a ttl in any order.
+func newCSVReader(body io.Reader) (*csvReader, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err == io.EOF {
+		return &csvReader{reader: reader}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("the CSV header is invalid: %w", err)
+	}
+	for i, column := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(column))
+		if column := header[i]; !slices.Contains(csvColumns, column) && !slices.Contains(csvReadOnlyColumns, column) && column != "ttl" {
+			return nil, fmt.Errorf("the CSV column %s is unknown", column)
+		}
+	}
+	return &csvReader{reader: reader, header: header}, nil
+}
+
+func (r *csvReader) next() (*CreateRequest, error) {
+	if r.header == nil {
+		return nil, io.EOF
+	}
+	record, err := r.reader.Read()
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return nil, &rowError{"row is invalid CSV: " + parseErr.Err.Error()}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(record) != len(r.header) {
+		return nil, &rowError{fmt.Sprintf("row must have %d fields", len(r.header))}
+	}
+
+	request := &CreateRequest{}
+	for i, value := range record {
+		if value == "" {
+			continue
+		}
+		switch column := r.header[i]; column {
+		case "key":
+			request.Key = value
+		case "url":
+			request.URL = value
+		case "ttl":
+			request.TTL = value
+		case "query_precedence":
+			request.QueryPrecedence = value
+		case "status":
+			status, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, &rowError{"status must be a number"}
+			}
+			request.Status = &status
+		case "expires_at":
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return nil, &rowError{"expires_at must be a RFC3339 timestamp"}
+			}
+			request.ExpiresAt = &t
+		case "forward_path", "forward_query":
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, &rowError{column + " must be true or false"}
+			}
+			if column == "forward_path" {
+				request.ForwardPath = b
+			} else {
+				request.ForwardQuery = b
+			}
+		default:
+			if name, ok := strings.CutPrefix(column, "utm_"); ok {
+				if request.UTM == nil {
+					request.UTM = utmParams{}
+				}
+				request.UTM[name] = value
+			}
+		}
+	}
+	return request, nil
+}
+
+// readImport reads and validates all rows of an import, it returns the result
+// of every row with the request of the valid ones, failed rows have a nil
+// request. It fails with errTooManyRows when there are more rows than the
+// configured maximum.
+func readImport(ctx context.Context, reader importReader, config *internal.Config, keys *keyPolicy) ([]*ImportRow, []*CreateRequest, error) {
+	rows := []*ImportRow{}
+	requests := []*CreateRequest{}
+	for n := 1; ; n++ {
+		request, err := reader.next()
+		if err == io.EOF {
+			return rows, requests, nil
+		}
+		if n > config.ImportMaxRows {
+			return nil, nil, errTooManyRows
+		}
+		row := &ImportRow{Row: n}
+		var rowErr *rowError
+		if errors.As(err, &rowErr) {
+			row.Result, row.Detail, request = rowFailed, rowErr.msg, nil
+		} else if err != nil {
+			return nil, nil, err
+		} else if problem := request.validate(ctx, config, keys); problem != nil {
+			row.Key = request.Key
+			row.Result, row.Detail, row.Errors, request = rowFailed, problem.Detail, problem.Errors, nil
+		} else {
+			row.Key = request.Key
+		}
+		rows = append(rows, row)
+		requests = append(requests, request)
+	}
+}
+
+// importer imports the rows of a single import in its transaction.
+type importer struct {
+	deps      *internal.Dependencies
+	keys      *keyPolicy
+	namespace string
+	mode      string
+	principal *auth.Principal
+}
+
+// row creates the redirection of a single validated row or, depending on the
+// mode, updates or skips the existing one. The result is recorded in row, only
+// database errors are returned.
+func (im *importer) row(ctx context.Context, tx *sql.Tx, request *CreateRequest, row *ImportRow) error {
+	redirection := &Redirection{
+		Namespace:       im.namespace,
+		Key:             request.Key,
+		URL:             request.URL,
+		Status:          request.Status,
+		ExpiresAt:       request.ExpiresAt,
+		ForwardPath:     request.ForwardPath,
+		ForwardQuery:    request.ForwardQuery,
+		QueryPrecedence: request.QueryPrecedence,
+		UTM:             request.UTM,
+		Owner:           im.principal.ID(),
+	}
+
+	var existing *Redirection
+	if redirection.Key != "" {
+		var err error
+		if existing, err = getRedirection(ctx, tx, im.namespace, redirection.Key); err != nil {
+			return err
+		}
+	}
+	if existing != nil {
+		switch {
+		case im.mode == importSkip:
+			row.Result = rowSkipped
+		case im.mode == importFail:
+			row.Result, row.Detail = rowFailed, "a redirection with this key already exists"
+		case !existing.manageableBy(im.principal):
+			row.Result, row.Detail = rowFailed, errNotOwner
+		default:
+			updated, err := updateRedirection(ctx, tx, redirection)
+			if err != nil {
+				return err
+			}
+			row.Result = rowUpdated
+			return recordAudit(ctx, tx, auditUpdate, existing, updated)
+		}
+		return nil
+	}
+
+	var err error
+	if redirection.Key != "" {
+		err = insertRedirection(ctx, tx, im.deps.Config, redirection)
+	} else {
+		err = insertWithGeneratedKey(ctx, tx, im.keys, redirection)
+	}
+	if err == errQuotaExceeded {
+		row.Result, row.Detail = rowFailed, "the quota of namespace "+im.namespace+" is exceeded"
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	row.Key, row.Result = redirection.Key, rowCreated
+	created, err := getRedirection(ctx, tx, im.namespace, redirection.Key)
+	if err != nil {
+		return err
+	}
+	return recordAudit(ctx, tx, auditCreate, nil, created)
+}
+
+// importRedirections creates the redirections of a JSON Lines or CSV body in
+// a single transaction, which is rolled back when any row fails.
+func importRedirections(deps *internal.Dependencies, keys *keyPolicy, lookups *redirectCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := logging.GetLogger(ctx)
+		namespace, ok := requestNamespace(w, r)
+		if !ok {
+			return
+		}
+
+		q := r.URL.Query()
+		mode := cmp.Or(q.Get("mode"), importFail)
+		if mode != importFail && mode != importSkip && mode != importUpsert {
+			httputil.Error(w, r, http.StatusBadRequest, "mode must be one of fail, skip or upsert")
+			return
+		}
+		// Rows have no If-Match, so upserts can't be conditional.
+		if mode == importUpsert && deps.Config.RequireIfMatch {
+			httputil.Error(w, r, http.StatusPreconditionRequired, "mode upsert is not allowed when If-Match is required")
+			return
+		}
+		var dryRun bool
+		if v := q.Get("dry_run"); v != "" {
+			var err error
+			if dryRun, err = strconv.ParseBool(v); err != nil {
+				httputil.Error(w, r, http.StatusBadRequest, "dry_run must be true or false")
+				return
+			}
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, int64(deps.Config.ImportMaxBytes))
+		reader, problem := newImportReader(r)
+		if problem != nil {
+			httputil.WriteProblem(w, r, problem)
+			return
+		}
+
+		// The body is read and validated before the transaction, so a slow
+		// client doesn't hold the write lock.
+		rows, requests, err := readImport(ctx, reader, deps.Config, keys)
+		var maxBytesErr *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesErr):
+			httputil.Error(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("the import must be at most %d bytes", maxBytesErr.Limit))
+			return
+		case err == errTooManyRows:
+			httputil.Error(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("the import must have at most %d rows", deps.Config.ImportMaxRows))
+			return
+		case err != nil:
+			httputil.Error(w, r, http.StatusBadRequest, "failed to read the import: "+err.Error())
+			return
+		}
+
+		im := &importer{deps: deps, keys: keys, namespace: namespace, mode: mode, principal: auth.GetPrincipal(ctx)}
+		response := &ImportResponse{DryRun: dryRun, Mode: mode, Rows: []*ImportRow{}}
+		err = sqlutil.InTx(ctx, deps.DB, func(tx *sql.Tx) error {
+			for i, row := range rows {
+				if request := requests[i]; request != nil {
+					if err := im.row(ctx, tx, request, row); err != nil {
+						return err
+					}
+				}
+				response.add(row)
+			}
+			if dryRun || response.Failed > 0 {
+				return errRollback
+			}
+			return nil
+		})
+		if err != nil && err != errRollback {
+			logger.Error("failed to import redirections", "err", err)
+			httputil.InternalError(w, r)
+			return
+		}
+		response.Committed = err == nil
+		if response.Committed {
+			for _, row := range response.Rows {
+				lookups.forget(namespace, row.Key)
+			}
+		}
+
+		status := http.StatusOK
+		if response.Failed > 0 {
+			status = http.StatusUnprocessableEntity
+		}
+		httputil.WriteJSON(w, status, response)
+
+		logger.Info("imported redirections", "namespace", namespace, "mode", mode, "dry_run", dryRun, "committed", response.Committed,
+			"created", response.Created, "updated", response.Updated, "skipped", response.Skipped, "failed", response.Failed)
+	}
+}
+
+// exportRedirections streams all redirections of the namespace as JSON Lines
+// or, when the client accepts it, as CSV. Like the list, callers other than
+// admins only export their own redirections. Expired redirections are left
+// out, they can't be imported and are only kept until Sweep purges them.
+func exportRedirections(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := logging.GetLogger(ctx)
+		namespace, ok := requestNamespace(w, r)
+		if !ok {
+			return
+		}
+
+		q := "SELECT " + redirectionColumns + " FROM redirection WHERE namespace = ? AND deleted_at IS NULL"
+		q += " AND (expires_at IS NULL OR datetime(expires_at) > datetime(?))"
+		args := []any{namespace, timeutil.Now(ctx).UTC().Format(time.DateTime)}
+		if principal := auth.GetPrincipal(ctx); !principal.Role.Includes(auth.RoleAdmin) {
+			q += " AND owner = ?"
+			args = append(args, principal.ID())
+		}
+		rows, err := db.QueryContext(ctx, q+" ORDER BY key", args...)
+		if err != nil {
+			logger.Error("failed to export redirections", "err", err)
+			httputil.InternalError(w, r)
+			return
+		}
+		defer rows.Close()
+
+		format := httputil.Negotiate(r, jsonLinesContentType, csvContentType)
+		extension := map[string]string{jsonLinesContentType: "jsonl", csvContentType: "csv"}[format]
+		w.Header().Set("Content-Type", format)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "redirections-"+namespace+"."+extension))
+
+		write := json.NewEncoder(w).Encode
+		if format == csvContentType {
+			writer := csv.NewWriter(w)
+			defer writer.Flush()
+			writer.Write(csvColumns) //nolint:errcheck
+			write = func(v any) error {
+				return writer.Write(csvRecord(v.(*Redirection)))
+			}
+		}
+
+		count := 0
+		for rows.Next() {
+			redirection, err := scanRedirection(rows)
+			if err == nil {
+				err = write(redirection)
+			}
+			if err != nil {
+				// The response has started, the best we can do is to abort it
+				// so the client doesn't mistake it for a complete export.
+				logger.Error("failed to export redirections", "err", err)
+				panic(http.ErrAbortHandler)
+			}
+			count++
+		}
+		if err := rows.Err(); err != nil {
+			logger.Error("failed to export redirections", "err", err)
+			panic(http.ErrAbortHandler)
+		}
+
+		logger.Info("exported redirections", "namespace", namespace, "format", extension, "count", count)
+	}
+}
+
+// csvRecord returns the csvColumns of the redirection.
+func csvRecord(redirection *Redirection) []string {
+	var status, expiresAt string
+	if redirection.Status != nil {
+		status = strconv.Itoa(*redirection.Status)
+	}
+	if redirection.ExpiresAt != nil {
+		expiresAt = redirection.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+	record := []string{
+		redirection.Key, redirection.URL, status, expiresAt,
+		strconv.FormatBool(redirection.ForwardPath), strconv.FormatBool(redirection.ForwardQuery), redirection.QueryPrecedence,
+	}
+	for _, name := range utmNames {
+		record = append(record, redirection.UTM[name])
+	}
+	return append(record,
+		redirection.Owner,
+		redirection.CreatedAt.UTC().Format(time.RFC3339),
+		redirection.UpdatedAt.UTC().Format(time.RFC3339),
+	)
+}
@@ -0,0 +1,105 @@
+package routes
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/cache"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/util/timeutil"
+)
+
+// redirectCache caches the redirection and domain lookups of redirects,
+// including the keys and hosts without one. Changes of a redirection, and
+// purges by Sweep, forget its key and changes of a domain forget its host,
+// which only affects the cache of this process.
+type redirectCache struct {
+	config  *internal.Config
+	lookups *cache.Cache[redirectKey, *Redirection]
+	domains *cache.Cache[string, *Domain]
+
+	// generation is incremented by every forget, a lookup that started
+	// before it may have read the old value and isn't cached.
+	mu         sync.Mutex
+	generation uint64
+}
+
+type redirectKey struct {
+	namespace, key string
+}
+
+func newRedirectCache(deps *internal.Dependencies) *redirectCache {
+	c := &redirectCache{
+		config:  deps.Config,
+		lookups: cache.New[redirectKey, *Redirection](deps.Config.RedirectCacheSize),
+		domains: cache.New[string, *Domain](deps.Config.RedirectCacheSize),
+	}
+	deps.Metric("redirect_cache", func() any { return c.lookups.Stats() })
+	deps.Metric("domain_cache", func() any { return c.domains.Stats() })
+	deps.OnDomainChange(c.forgetDomain)
+	return c
+}
+
+// get returns the redirection like getRedirection, from the cache when it was
+// looked up before. The returned redirection is shared and must not be
+// changed.
+func (c *redirectCache) get(ctx context.Context, db *sql.DB, namespace, key string) (*Redirection, error) {
+	return lookup(ctx, c, c.lookups, redirectKey{namespace, key}, func() (*Redirection, error) {
+		return getRedirection(ctx, db, namespace, key)
+	})
+}
+
+// domain returns the domain like getDomain, from the cache when it was looked
+// up before. The returned domain is shared and must not be changed.
+func (c *redirectCache) domain(ctx context.Context, db *sql.DB, host string) (*Domain, error) {
+	return lookup(ctx, c, c.domains, host, func() (*Domain, error) {
+		return getDomain(ctx, db, host)
+	})
+}
+
+// lookup returns the value of k from the cache, or fetches and caches it. A
+// nil value is cached for the shorter negative TTL.
+func lookup[K comparable, V any](ctx context.Context, c *redirectCache, values *cache.Cache[K, *V], k K, fetch func() (*V, error)) (*V, error) {
+	now := timeutil.Now(ctx)
+	if value, ok := values.Get(k, now); ok {
+		return value, nil
+	}
+	c.mu.Lock()
+	generation := c.generation
+	c.mu.Unlock()
+	value, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+	ttl := c.config.RedirectCacheTTL
+	if value == nil {
+		ttl = c.config.RedirectCacheNegativeTTL
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation == generation {
+		values.Set(k, value, now.Add(ttl))
+	}
+	return value, nil
+}
+
+// forget removes the keys of the namespace from the cache, it should be
+// called after they are changed.
+func (c *redirectCache) forget(namespace string, keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	for _, key := range keys {
+		c.lookups.Delete(redirectKey{namespace, key})
+	}
+}
+
+// forgetDomain removes the host from the cache, it should be called after its
+// domain is changed.
+func (c *redirectCache) forgetDomain(host string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.domains.Delete(host)
+}
@@ -0,0 +1,318 @@
+package routes
+
+import (
+	"context"
+	"database/sql"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/auth"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/util/httputil"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/util/sqlutil"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/util/timeutil"
+	"github.com/koenbollen/logging"
+)
+
+// domainColumns are the columns scanned by scanDomain, in order.
+const domainColumns = "host, namespace, not_found_url, created_at, updated_at"
+
+// hostName are the valid host names of a domain.
+var hostName = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)*$`)
+
+// Domain is a host the service redirects on. Redirects on the host resolve in
+// its namespace, and a redirect that isn't found is sent to NotFoundURL
+// instead of responding with 404.
+type Domain struct {
+	Host        string    `json:"host"`
+	Namespace   string    `json:"namespace"`
+	NotFoundURL string    `json:"not_found_url,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// DomainRequest is used to create (POST) or replace (PUT) a domain, the host
+// of a PUT is taken from the path.
+type DomainRequest struct {
+	Host        string `json:"host"`
+	Namespace   string `json:"namespace"`
+	NotFoundURL string `json:"not_found_url"`
+}
+
+// DomainListResponse are all domains, sorted by host.
+type DomainListResponse struct {
+	Items []*Domain `json:"items"`
+}
+
+// normalizeHost lowercases the host and removes its port and trailing dot.
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+func (req *DomainRequest) validate(config *internal.Config) *httputil.Problem {
+	problem := httputil.NewProblem(http.StatusBadRequest, "the domain is invalid")
+	req.Host = normalizeHost(req.Host)
+	if req.Host == "" {
+		problem.WithFieldError("host", "is required")
+	} else if !hostName.MatchString(req.Host) {
+		problem.WithFieldError("host", "is not a valid host name")
+	}
+	if req.Namespace == "" {
+		req.Namespace = defaultNamespace
+	} else if !namespaceName.MatchString(req.Namespace) {
+		problem.WithFieldError("namespace", "is not a valid namespace")
+	}
+	if req.NotFoundURL != "" {
+		normalized, msg := normalizeURL(config, req.NotFoundURL)
+		if msg != "" {
+			problem.WithFieldError("not_found_url", msg)
+		}
+		req.NotFoundURL = normalized
+	}
+	if problem.HasErrors() {
+		return problem
+	}
+	return nil
+}
+
+func scanDomain(row scanner) (*Domain, error) {
+	domain := &Domain{}
+	var notFoundURL sql.NullString
+	if err := row.Scan(&domain.Host, &domain.Namespace, &notFoundURL, &domain.CreatedAt, &domain.UpdatedAt); err != nil {
+		return nil, err
+	}
+	domain.NotFoundURL = notFoundURL.String
+	return domain, nil
+}
+
+// getDomain fetches a single domain by host, it returns nil if the domain
+// does not exist.
+func getDomain(ctx context.Context, db *sql.DB, host string) (*Domain, error) {
+	row := db.QueryRowContext(ctx, "SELECT "+domainColumns+" FROM domain WHERE host = ?", host)
+	domain, err := scanDomain(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return domain, err
+}
+
+// redirectScope is where a redirect is resolved: the namespace with the key
+// and rest of the path within it, and the domain it's served on if known.
+type redirectScope struct {
+	namespace string
+	key       string
+	rest      string
+	domain    *Domain
+}
+
+// resolveRedirect determines the scope of a redirect. The namespace is taken
+// from, in order: the domain of the host, a subdomain of the NamespaceDomain,
+// a path starting with ~namespace, the DefaultDomain or the default. Hosts
+// that resolve a namespace themselves can't be used for other namespaces, so
+// they don't resolve a ~namespace path. Domains are looked up in the cache.
+func resolveRedirect(ctx context.Context, db *sql.DB, lookups *redirectCache, config *internal.Config, r *http.Request) (*redirectScope, error) {
+	scope := &redirectScope{
+		namespace: defaultNamespace,
+		key:       r.PathValue("key"),
+		rest:      r.PathValue("rest"),
+	}
+
+	host := normalizeHost(r.Host)
+	domain, err := lookups.domain(ctx, db, host)
+	if err != nil {
+		return nil, err
+	}
+	subdomain, isSubdomain := strings.CutSuffix(host, "."+strings.ToLower(config.NamespaceDomain))
+	name, hasPrefix := strings.CutPrefix(scope.key, "~")
+	switch {
+	case domain != nil:
+		scope.domain = domain
+		scope.namespace = domain.Namespace
+	case config.NamespaceDomain != "" && isSubdomain && namespaceName.MatchString(subdomain):
+		scope.namespace = subdomain
+	case hasPrefix && namespaceName.MatchString(name):
+		scope.namespace = name
+		scope.key, scope.rest, _ = strings.Cut(scope.rest, "/")
+	case config.DefaultDomain != "":
+		if scope.domain, err = lookups.domain(ctx, db, normalizeHost(config.DefaultDomain)); err != nil {
+			return nil, err
+		}
+		if scope.domain != nil {
+			scope.namespace = scope.domain.Namespace
+		}
+	}
+	return scope, nil
+}
+
+// notFound responds to a redirect that can't be resolved, with a redirect to
+// the not found URL of the domain or else a 404.
+func (scope *redirectScope) notFound(w http.ResponseWriter, r *http.Request) {
+	if scope.domain != nil && scope.domain.NotFoundURL != "" {
+		writeRedirect(w, r, scope.domain.NotFoundURL, http.StatusFound)
+		return
+	}
+	httputil.Error(w, r, http.StatusNotFound, "redirection not found")
+}
+
+// nullString stores empty strings as NULL.
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// Domains allows clients to manage the hosts redirects are served on.
+func Domains(ctx context.Context, mux *http.ServeMux, deps *internal.Dependencies) error {
+	db := deps.DB
+
+	mux.HandleFunc("POST /domains", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := logging.GetLogger(ctx)
+		request := &DomainRequest{}
+		if !httputil.DecodeJSON(w, r, request) {
+			return
+		}
+		if problem := request.validate(deps.Config); problem != nil {
+			httputil.WriteProblem(w, r, problem)
+			return
+		}
+
+		now := timeutil.Now(ctx)
+		_, err := db.ExecContext(ctx, "INSERT INTO domain (host, namespace, not_found_url, created_at, updated_at) VALUES (?, ?, ?, ?, ?)",
+			request.Host, request.Namespace, nullString(request.NotFoundURL), now, now)
+		if sqlutil.IsUniqueViolation(err) {
+			httputil.WriteProblem(w, r, httputil.NewProblem(http.StatusConflict, "a domain with this host already exists").WithFieldError("host", "already exists"))
+			return
+		}
+		if err != nil {
+			logger.Error("failed to create domain", "err", err)
+			httputil.InternalError(w, r)
+			return
+		}
+		deps.DomainChanged(request.Host)
+
+		domain, err := getDomain(ctx, db, request.Host)
+		if err != nil || domain == nil {
+			logger.Error("failed to query created domain", "err", err)
+			httputil.InternalError(w, r)
+			return
+		}
+		w.Header().Set("Location", "/domains/"+url.PathEscape(domain.Host))
+		httputil.WriteJSON(w, http.StatusCreated, domain)
+
+		logger.Info("created domain", "host", domain.Host, "namespace", domain.Namespace)
+	})
+
+	mux.HandleFunc("GET /domains", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := logging.GetLogger(ctx)
+
+		rows, err := db.QueryContext(ctx, "SELECT "+domainColumns+" FROM domain ORDER BY host")
+		if err != nil {
+			logger.Error("failed to query domains", "err", err)
+			httputil.InternalError(w, r)
+			return
+		}
+		defer rows.Close()
+		response := &DomainListResponse{Items: []*Domain{}}
+		for rows.Next() {
+			domain, err := scanDomain(rows)
+			if err != nil {
+				logger.Error("failed to scan domain", "err", err)
+				httputil.InternalError(w, r)
+				return
+			}
+			response.Items = append(response.Items, domain)
+		}
+		if err := rows.Err(); err != nil {
+			logger.Error("failed to query domains", "err", err)
+			httputil.InternalError(w, r)
+			return
+		}
+		httputil.WriteJSON(w, http.StatusOK, response)
+	})
+
+	mux.HandleFunc("GET /domains/{host}", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := logging.GetLogger(ctx)
+
+		domain, err := getDomain(ctx, db, normalizeHost(r.PathValue("host")))
+		if err != nil {
+			logger.Error("failed to query domain", "err", err)
+			httputil.InternalError(w, r)
+			return
+		}
+		if domain == nil {
+			httputil.Error(w, r, http.StatusNotFound, "domain not found")
+			return
+		}
+		httputil.WriteJSON(w, http.StatusOK, domain)
+	})
+
+	mux.HandleFunc("PUT /domains/{host}", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := logging.GetLogger(ctx)
+		request := &DomainRequest{}
+		if !httputil.DecodeJSON(w, r, request) {
+			return
+		}
+		request.Host = r.PathValue("host")
+		if problem := request.validate(deps.Config); problem != nil {
+			httputil.WriteProblem(w, r, problem)
+			return
+		}
+
+		result, err := db.ExecContext(ctx, "UPDATE domain SET namespace = ?, not_found_url = ?, updated_at = ? WHERE host = ?",
+			request.Namespace, nullString(request.NotFoundURL), timeutil.Now(ctx), request.Host)
+		var n int64
+		if err == nil {
+			n, err = result.RowsAffected()
+		}
+		if err != nil {
+			logger.Error("failed to update domain", "err", err)
+			httputil.InternalError(w, r)
+			return
+		}
+		if n == 0 {
+			httputil.Error(w, r, http.StatusNotFound, "domain not found")
+			return
+		}
+		deps.DomainChanged(request.Host)
+
+		domain, err := getDomain(ctx, db, request.Host)
+		if err != nil || domain == nil {
+			logger.Error("failed to query updated domain", "err", err)
+			httputil.InternalError(w, r)
+			return
+		}
+		httputil.WriteJSON(w, http.StatusOK, domain)
+
+		logger.Info("updated domain", "host", domain.Host, "namespace", domain.Namespace)
+	})
+
+	mux.HandleFunc("DELETE /domains/{host}", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := logging.GetLogger(ctx)
+		host := normalizeHost(r.PathValue("host"))
+
+		if _, err := db.ExecContext(ctx, "DELETE FROM domain WHERE host = ?", host); err != nil {
+			logger.Error("failed to delete domain", "err", err)
+			httputil.InternalError(w, r)
+			return
+		}
+		deps.DomainChanged(host)
+		w.WriteHeader(http.StatusNoContent)
+
+		logger.Info("deleted domain", "host", host)
+	})
+
+	deps.Require(auth.RoleViewer, "GET /domains", "GET /domains/{host}")
+	deps.Require(auth.RoleAdmin, "POST /domains", "PUT /domains/{host}", "DELETE /domains/{host}")
+	deps.Idempotent("POST /domains", "PUT /domains/{host}", "DELETE /domains/{host}")
+	return nil
+}
@@ -0,0 +1,94 @@
+package routes
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Query precedences decide which value is kept when a query parameter is both
+// in the target URL and in the request.
+const (
+	precedenceTarget  = "target"
+	precedenceRequest = "request"
+)
+
+// errDotSegment is returned by targetURL for a forwarded path with a "." or
+// ".." segment, which could resolve outside of the path of the target.
+var errDotSegment = errors.New("forwarded path has a dot segment")
+
+// utmNames are the UTM parameters that can be injected into the target URL,
+// without their "utm_" prefix.
+var utmNames = []string{"source", "medium", "campaign", "term", "content"}
+
+// utmParams are the UTM parameters injected into the target URL, keyed by
+// name without the "utm_" prefix. They are stored as JSON.
+type utmParams map[string]string
+
+func (u utmParams) Value() (driver.Value, error) {
+	if len(u) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(u)
+	return string(data), err
+}
+
+func (u *utmParams) Scan(src any) error {
+	*u = nil
+	switch v := src.(type) {
+	case nil:
+		return nil
+	case string:
+		return json.Unmarshal([]byte(v), u)
+	case []byte:
+		return json.Unmarshal(v, u)
+	}
+	return fmt.Errorf("can not scan %T into utm parameters", src)
+}
+
+// targetURL builds the URL to redirect the request to. Depending on the
+// redirection the remaining path and query of the request are forwarded, and
+// the UTM parameters are injected. It returns errDotSegment when the forwarded
+// path has a "." or ".." segment.
+func (r *Redirection) targetURL(req *http.Request, rest string) (string, error) {
+	forwardQuery := r.ForwardQuery && req.URL.RawQuery != ""
+	if (!r.ForwardPath || rest == "") && !forwardQuery && len(r.UTM) == 0 {
+		return r.URL, nil
+	}
+	u, err := url.Parse(r.URL)
+	if err != nil {
+		return "", err
+	}
+
+	if r.ForwardPath && rest != "" {
+		segments := strings.Split(rest, "/")
+		for _, segment := range segments {
+			if segment == "." || segment == ".." {
+				return "", errDotSegment
+			}
+		}
+		u = u.JoinPath(segments...)
+	}
+
+	if !forwardQuery && len(r.UTM) == 0 {
+		return u.String(), nil
+	}
+	query := u.Query()
+	if forwardQuery {
+		for name, values := range req.URL.Query() {
+			if query.Has(name) && r.QueryPrecedence != precedenceRequest {
+				continue
+			}
+			query[name] = values
+		}
+	}
+	for name, value := range r.UTM {
+		query.Set("utm_"+name, value)
+	}
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
@@ -0,0 +1,78 @@
+package routes
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/util/randutil"
+)
+
+// redirectPattern is the catch all pattern that resolves redirections, keys
+// that resolve to any other pattern are reserved.
+const redirectPattern = "GET /{key}"
+
+// maxGenerateAttempts is the number of keys generated before giving up on
+// finding one that isn't reserved or taken.
+const maxGenerateAttempts = 5
+
+// keyPolicy validates redirection keys and generates new ones.
+type keyPolicy struct {
+	config *internal.Config
+	mux    *http.ServeMux
+}
+
+// validate returns a message explaining why key is not allowed, or an empty
+// string if it is.
+func (p *keyPolicy) validate(key string) string {
+	if n := len(key); n < p.config.KeyMinLength || n > p.config.KeyMaxLength {
+		return fmt.Sprintf("must be between %d and %d characters", p.config.KeyMinLength, p.config.KeyMaxLength)
+	}
+	for _, c := range key {
+		if !strings.ContainsRune(p.config.KeyCharset, c) {
+			return fmt.Sprintf("can not contain %q", c)
+		}
+	}
+	if p.reserved(key) {
+		return "is reserved"
+	}
+	return ""
+}
+
+// reserved reports if the key is in the configured reserved list or would be
+// shadowed by another route registered on the mux, e.g. "health".
+func (p *keyPolicy) reserved(key string) bool {
+	if slices.ContainsFunc(p.config.ReservedKeys, func(reserved string) bool {
+		return strings.EqualFold(reserved, key)
+	}) {
+		return true
+	}
+	r, err := http.NewRequest(http.MethodGet, "/"+url.PathEscape(key), nil)
+	if err != nil {
+		return true
+	}
+	_, pattern := p.mux.Handler(r)
+	return pattern != redirectPattern
+}
+
+// generate returns a random key of the configured alphabet and length, it's
+// up to the caller to handle collisions with existing keys.
+func (p *keyPolicy) generate(ctx context.Context) (string, error) {
+	alphabet := []rune(p.config.GeneratedKeyAlphabet)
+	max := big.NewInt(int64(len(alphabet)))
+	key := make([]rune, p.config.GeneratedKeyLength)
+	for i := range key {
+		n, err := rand.Int(randutil.Reader(ctx), max)
+		if err != nil {
+			return "", err
+		}
+		key[i] = alphabet[n.Int64()]
+	}
+	return string(key), nil
+}
@@ -0,0 +1,267 @@
+package routes
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/auth"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/util/httputil"
+	"github.com/koenbollen/logging"
+)
+
+const (
+	defaultListLimit = 20
+	maxListLimit     = 100
+)
+
+// sortColumns maps the allowed values of the sort parameter to the SQL
+// expression to sort on. Timestamps are normalized through datetime() so rows
+// written in different formats still order correctly.
+var sortColumns = map[string]string{
+	"key":        "key",
+	"created_at": "datetime(created_at)",
+	"updated_at": "datetime(updated_at)",
+}
+
+// ListResponse is the envelope returned when listing redirections.
+type ListResponse struct {
+	Items      []*Redirection `json:"items"`
+	Limit      int            `json:"limit"`
+	HasMore    bool           `json:"has_more"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+// cursor is the position after the last item of a page, it's given to clients
+// as an opaque base64 string.
+type cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	Key   string `json:"k"`
+}
+
+func (c *cursor) encode() string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	c := &cursor{}
+	if err := json.Unmarshal(raw, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// listQuery is the parsed form of the query parameters of GET /redirections.
+type listQuery struct {
+	namespace  string
+	limit      int
+	sort       string
+	descending bool
+	cursor     *cursor
+
+	// owner limits the list to the redirections of this owner, when set.
+	owner          string
+	includeDeleted bool
+
+	keyPrefix   string
+	urlContains string
+	ranges      []timeRange
+}
+
+type timeRange struct {
+	column string
+	op     string
+	value  time.Time
+}
+
+func parseListQuery(namespace string, q url.Values) (*listQuery, error) {
+	query := &listQuery{
+		namespace: namespace,
+		limit:     defaultListLimit,
+		sort:      "key",
+	}
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxListLimit {
+			return nil, fmt.Errorf("limit must be a number between 1 and %d", maxListLimit)
+		}
+		query.limit = limit
+	}
+
+	if v := q.Get("sort"); v != "" {
+		query.descending = strings.HasPrefix(v, "-")
+		query.sort = strings.TrimPrefix(v, "-")
+		if _, ok := sortColumns[query.sort]; !ok {
+			return nil, fmt.Errorf("sort must be one of key, created_at or updated_at, optionally prefixed with -")
+		}
+	}
+
+	if v := q.Get("cursor"); v != "" {
+		c, err := decodeCursor(v)
+		if err != nil || c.Sort != query.sortParam() {
+			return nil, fmt.Errorf("cursor is invalid or does not match the given sort")
+		}
+		query.cursor = c
+	}
+
+	if v := q.Get("include_deleted"); v != "" {
+		include, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("include_deleted must be true or false")
+		}
+		query.includeDeleted = include
+	}
+
+	query.keyPrefix = q.Get("key_prefix")
+	query.urlContains = q.Get("url_contains")
+
+	for _, param := range []struct {
+		name, column, op string
+	}{
+		{"created_after", "created_at", ">="},
+		{"created_before", "created_at", "<"},
+		{"updated_after", "updated_at", ">="},
+		{"updated_before", "updated_at", "<"},
+	} {
+		v := q.Get(param.name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be a RFC3339 timestamp", param.name)
+		}
+		query.ranges = append(query.ranges, timeRange{param.column, param.op, t})
+	}
+
+	return query, nil
+}
+
+// sortParam returns the sort as given by clients, e.g. "-created_at".
+func (query *listQuery) sortParam() string {
+	if query.descending {
+		return "-" + query.sort
+	}
+	return query.sort
+}
+
+// sql builds the SELECT statement and its arguments for this query, it fetches
+// one row more than the limit to determine if there is a next page.
+func (query *listQuery) sql() (string, []any) {
+	where := []string{"namespace = ?"}
+	args := []any{query.namespace}
+
+	if query.owner != "" {
+		where = append(where, "owner = ?")
+		args = append(args, query.owner)
+	}
+	if !query.includeDeleted {
+		where = append(where, "deleted_at IS NULL")
+	}
+	if query.keyPrefix != "" {
+		where = append(where, "substr(key, 1, ?) = ?")
+		args = append(args, len(query.keyPrefix), query.keyPrefix)
+	}
+	if query.urlContains != "" {
+		where = append(where, "instr(url, ?) > 0")
+		args = append(args, query.urlContains)
+	}
+	for _, r := range query.ranges {
+		where = append(where, "datetime("+r.column+") "+r.op+" datetime(?)")
+		args = append(args, r.value.UTC().Format(time.DateTime))
+	}
+
+	column := sortColumns[query.sort]
+	direction, op := "ASC", ">"
+	if query.descending {
+		direction, op = "DESC", "<"
+	}
+	if query.cursor != nil {
+		if query.sort == "key" {
+			where = append(where, "key "+op+" ?")
+			args = append(args, query.cursor.Key)
+		} else {
+			where = append(where, "("+column+" "+op+" ? OR ("+column+" = ? AND key "+op+" ?))")
+			args = append(args, query.cursor.Value, query.cursor.Value, query.cursor.Key)
+		}
+	}
+
+	q := "SELECT " + redirectionColumns + ", " + column + " FROM redirection"
+	q += " WHERE " + strings.Join(where, " AND ")
+	q += " ORDER BY " + column + " " + direction + ", key " + direction
+	q += " LIMIT " + strconv.Itoa(query.limit+1)
+	return q, args
+}
+
+func listRedirections(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := logging.GetLogger(ctx)
+		namespace, ok := requestNamespace(w, r)
+		if !ok {
+			return
+		}
+
+		query, err := parseListQuery(namespace, r.URL.Query())
+		if err != nil {
+			httputil.Error(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		if principal := auth.GetPrincipal(ctx); !principal.Role.Includes(auth.RoleAdmin) {
+			query.owner = principal.ID()
+		}
+
+		q, args := query.sql()
+		rows, err := db.QueryContext(ctx, q, args...)
+		if err != nil {
+			logger.Error("failed to list redirections", "err", err)
+			httputil.InternalError(w, r)
+			return
+		}
+		defer rows.Close()
+
+		response := &ListResponse{
+			Items: []*Redirection{},
+			Limit: query.limit,
+		}
+		var last sql.NullString
+		for rows.Next() {
+			if len(response.Items) == query.limit {
+				response.HasMore = true
+				break
+			}
+			redirection, err := scanRedirection(rows, &last)
+			if err != nil {
+				logger.Error("failed to scan redirection", "err", err)
+				httputil.InternalError(w, r)
+				return
+			}
+			response.Items = append(response.Items, redirection)
+		}
+		if err := rows.Err(); err != nil {
+			logger.Error("failed to list redirections", "err", err)
+			httputil.InternalError(w, r)
+			return
+		}
+
+		if response.HasMore {
+			lastItem := response.Items[len(response.Items)-1]
+			response.NextCursor = (&cursor{Sort: query.sortParam(), Value: last.String, Key: lastItem.Key}).encode()
+		}
+
+		httputil.WriteJSON(w, http.StatusOK, response)
+	}
+}
@@ -0,0 +1,171 @@
+package routes
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"regexp"
+	"slices"
+
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/auth"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/util/httputil"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/util/timeutil"
+	"github.com/koenbollen/logging"
+)
+
+// defaultNamespace is used when a request doesn't specify a namespace.
+const defaultNamespace = "default"
+
+// namespaceHeader selects the namespace of the /redirections endpoints, see
+// canUseNamespace for the namespaces a caller can select.
+const namespaceHeader = "X-Namespace"
+
+// namespaceName are the valid names of a namespace.
+var namespaceName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+// errQuotaExceeded is returned when a namespace has no room for another
+// redirection.
+var errQuotaExceeded = errors.New("namespace quota exceeded")
+
+// Namespace is the quota and usage of a namespace.
+type Namespace struct {
+	Name            string `json:"name"`
+	MaxRedirections *int   `json:"max_redirections"`
+	Redirections    int    `json:"redirections"`
+}
+
+// NamespaceRequest is used to change (PUT) the quota of a namespace, without
+// max_redirections the configured default quota applies.
+type NamespaceRequest struct {
+	MaxRedirections *int `json:"max_redirections"`
+}
+
+// requestNamespace returns the namespace selected with the namespace header.
+// It responds with a problem and returns false when the name is invalid or
+// the caller can't use the namespace.
+func requestNamespace(w http.ResponseWriter, r *http.Request) (string, bool) {
+	name := r.Header.Get(namespaceHeader)
+	if name == "" {
+		name = defaultNamespace
+	} else if !namespaceName.MatchString(name) {
+		httputil.Error(w, r, http.StatusBadRequest, "invalid "+namespaceHeader+" header")
+		return "", false
+	}
+	if !canUseNamespace(auth.GetPrincipal(r.Context()), name) {
+		httputil.Error(w, r, http.StatusForbidden, errNamespaceNotAllowed(name))
+		return "", false
+	}
+	return name, true
+}
+
+// canUseNamespace reports if the principal can use the namespace. Admins can
+// use all namespaces, others the namespaces of their API key or JWT, or the
+// default namespace when they have none.
+func canUseNamespace(principal *auth.Principal, name string) bool {
+	switch {
+	case principal == nil || principal.Role.Includes(auth.RoleAdmin):
+		return true
+	case len(principal.Namespaces) == 0:
+		return name == defaultNamespace
+	}
+	return slices.Contains(principal.Namespaces, name)
+}
+
+// errNamespaceNotAllowed is the detail of the problem when a principal can't
+// use a namespace.
+func errNamespaceNotAllowed(name string) string {
+	return "the namespace " + name + " is not allowed"
+}
+
+// getNamespace returns the quota and usage of the namespace, namespaces
+// exist implicitly so this never returns nil.
+func getNamespace(ctx context.Context, db *sql.DB, name string) (*Namespace, error) {
+	namespace := &Namespace{Name: name}
+	var quota sql.NullInt64
+	err := db.QueryRowContext(ctx, "SELECT max_redirections FROM namespace WHERE name = ?", name).Scan(&quota)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if quota.Valid {
+		n := int(quota.Int64)
+		namespace.MaxRedirections = &n
+	}
+	err = db.QueryRowContext(ctx, "SELECT COUNT(*) FROM redirection WHERE namespace = ? AND deleted_at IS NULL", name).Scan(&namespace.Redirections)
+	return namespace, err
+}
+
+// Namespaces allows clients to view the usage and change the quota of a
+// namespace. Namespaces exist implicitly, the redirections of a namespace are
+// managed with the namespace header on the /redirections endpoints.
+func Namespaces(ctx context.Context, mux *http.ServeMux, deps *internal.Dependencies) error {
+	db := deps.DB
+
+	mux.HandleFunc("GET /namespaces/{name}", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := logging.GetLogger(ctx)
+		name := r.PathValue("name")
+		if !namespaceName.MatchString(name) {
+			httputil.Error(w, r, http.StatusNotFound, "namespace not found")
+			return
+		}
+		if !canUseNamespace(auth.GetPrincipal(ctx), name) {
+			httputil.Error(w, r, http.StatusForbidden, errNamespaceNotAllowed(name))
+			return
+		}
+
+		namespace, err := getNamespace(ctx, db, name)
+		if err != nil {
+			logger.Error("failed to query namespace", "err", err)
+			httputil.InternalError(w, r)
+			return
+		}
+		httputil.WriteJSON(w, http.StatusOK, namespace)
+	})
+
+	mux.HandleFunc("PUT /namespaces/{name}", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := logging.GetLogger(ctx)
+		name := r.PathValue("name")
+		if !namespaceName.MatchString(name) {
+			httputil.Error(w, r, http.StatusNotFound, "namespace not found")
+			return
+		}
+		request := &NamespaceRequest{}
+		if !httputil.DecodeJSON(w, r, request) {
+			return
+		}
+		if request.MaxRedirections != nil && *request.MaxRedirections < 0 {
+			problem := httputil.NewProblem(http.StatusBadRequest, "the namespace is invalid")
+			httputil.WriteProblem(w, r, problem.WithFieldError("max_redirections", "can not be negative"))
+			return
+		}
+
+		now := timeutil.Now(ctx)
+		_, err := db.ExecContext(ctx, `
+			INSERT INTO namespace (name, max_redirections, created_at, updated_at) VALUES (?, ?, ?, ?)
+			ON CONFLICT (name) DO UPDATE SET max_redirections = excluded.max_redirections, updated_at = excluded.updated_at
+		`, name, request.MaxRedirections, now, now)
+		if err != nil {
+			logger.Error("failed to update namespace", "err", err)
+			httputil.InternalError(w, r)
+			return
+		}
+
+		namespace, err := getNamespace(ctx, db, name)
+		if err != nil {
+			logger.Error("failed to query namespace", "err", err)
+			httputil.InternalError(w, r)
+			return
+		}
+		httputil.WriteJSON(w, http.StatusOK, namespace)
+
+		logger.Info("updated namespace", "name", name, "max_redirections", request.MaxRedirections)
+	})
+
+	deps.Require(auth.RoleViewer, "GET /namespaces/{name}")
+	deps.Require(auth.RoleAdmin, "PUT /namespaces/{name}")
+	deps.Idempotent("PUT /namespaces/{name}")
+	return nil
+}
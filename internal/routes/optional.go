@@ -0,0 +1,20 @@
+package routes
+
+import "encoding/json"
+
+// optional is a field of a PATCH request that distinguishes between a field
+// that's absent (Set is false), null (Value is nil) or given.
+type optional[T any] struct {
+	Set   bool
+	Value *T
+}
+
+func (o *optional[T]) UnmarshalJSON(data []byte) error {
+	o.Set = true
+	if string(data) == "null" {
+		o.Value = nil
+		return nil
+	}
+	o.Value = new(T)
+	return json.Unmarshal(data, o.Value)
+}
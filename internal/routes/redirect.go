@@ -0,0 +1,45 @@
+package routes
+
+import (
+	"html/template"
+	"net/http"
+
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/util/httputil"
+)
+
+// redirectTemplate is the body of a redirect response for clients that don't
+// follow the Location header. All values are escaped by html/template for the
+// context they appear in.
+var redirectTemplate = template.Must(template.New("redirect").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="0; url={{.}}">
+<title>Redirecting</title>
+</head>
+<body>
+<p>Redirecting to <a href="{{.}}">{{.}}</a>.</p>
+<script>window.location.replace({{.}});</script>
+</body>
+</html>`))
+
+// RedirectResponse is the body of a redirect for API clients that prefer JSON.
+type RedirectResponse struct {
+	URL string `json:"url"`
+}
+
+// writeRedirect redirects the client to target with the given status code.
+// The body is negotiated, an HTML page by default or JSON when requested.
+func writeRedirect(w http.ResponseWriter, r *http.Request, target string, status int) {
+	w.Header().Set("Location", target)
+	w.Header().Add("Vary", "Accept")
+
+	if httputil.Negotiate(r, "text/html", "application/json") == "application/json" {
+		httputil.WriteJSON(w, status, &RedirectResponse{URL: target})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	redirectTemplate.Execute(w, target) //nolint:errcheck
+}
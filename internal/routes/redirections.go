@@ -1,100 +1,532 @@
-// routes is just an example routes package that allows clients to create, and
-// delete redirection. And a catch all GET route to redirect to the URL. A lot
-// of functionality is missing.
+// routes is just an example routes package that allows clients to create, read,
+// update and delete redirection. And a catch all GET route to redirect to the
+// URL. A lot of functionality is missing.
 package routes
 
 import (
 	"context"
 	"database/sql"
-	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"slices"
+	"time"
 
 	"github.com/koenbollen/go-tested-api-with-sqlite/internal"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/auth"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/hits"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/util/httputil"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/util/sqlutil"
 	"github.com/koenbollen/go-tested-api-with-sqlite/internal/util/timeutil"
 	"github.com/koenbollen/logging"
 )
 
+// CreateRequest is used to create a redirection, a key is generated when it's
+// left empty. An expiry can be given either absolute or as ttl (e.g. "24h").
 type CreateRequest struct {
-	Key string `json:"key"`
-	URL string `json:"url"`
+	Key       string     `json:"key"`
+	URL       string     `json:"url"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	TTL       string     `json:"ttl"`
+	Status    *int       `json:"status"`
+
+	ForwardPath     bool      `json:"forward_path"`
+	ForwardQuery    bool      `json:"forward_query"`
+	QueryPrecedence string    `json:"query_precedence"`
+	UTM             utmParams `json:"utm"`
+}
+
+// UpdateRequest is used to replace (PUT) a redirection.
+type UpdateRequest struct {
+	URL       string     `json:"url"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	TTL       string     `json:"ttl"`
+	Status    *int       `json:"status"`
+
+	ForwardPath     bool      `json:"forward_path"`
+	ForwardQuery    bool      `json:"forward_query"`
+	QueryPrecedence string    `json:"query_precedence"`
+	UTM             utmParams `json:"utm"`
+}
+
+// PatchRequest is used to partially update (PATCH) a redirection, only the
+// given fields are changed. An expiry is removed with "expires_at": null, the
+// default status is restored with "status": null and the UTM parameters are
+// removed with "utm": null.
+type PatchRequest struct {
+	URL       *string             `json:"url"`
+	ExpiresAt optional[time.Time] `json:"expires_at"`
+	TTL       *string             `json:"ttl"`
+	Status    optional[int]       `json:"status"`
+
+	ForwardPath     *bool               `json:"forward_path"`
+	ForwardQuery    *bool               `json:"forward_query"`
+	QueryPrecedence *string             `json:"query_precedence"`
+	UTM             optional[utmParams] `json:"utm"`
+}
+
+// Redirection is a stored redirection as returned by the API. Without a
+// status the configured default redirect status is used.
+//
+// With ForwardPath the path after the key is appended to the URL and with
+// ForwardQuery the query parameters of the request are merged into it, the
+// QueryPrecedence decides which side wins when a parameter is in both, by
+// default the target.
+//
+// Deleted redirections are kept with DeletedAt until they are purged, in the
+// meantime they can be restored.
+type Redirection struct {
+	Namespace string     `json:"namespace"`
+	Key       string     `json:"key"`
+	URL       string     `json:"url"`
+	Status    *int       `json:"status,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+
+	ForwardPath     bool      `json:"forward_path,omitempty"`
+	ForwardQuery    bool      `json:"forward_query,omitempty"`
+	QueryPrecedence string    `json:"query_precedence,omitempty"`
+	UTM             utmParams `json:"utm,omitempty"`
+
+	// Owner is the ID of the principal that created the redirection.
+	Owner     string     `json:"owner,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// Version is incremented by every change, together with CreatedAt it's
+	// the ETag of the redirection.
+	Version int `json:"version"`
+}
+
+// errNotOwner is the detail of the problem when a principal can't manage a
+// redirection of someone else.
+const errNotOwner = "the redirection is owned by someone else"
+
+// errChanged is the detail of the problem when the If-Match header of a change
+// doesn't match the current version of the redirection.
+const errChanged = "the redirection has been changed since it was read"
+
+// ifMatchGiven writes a 428 problem and returns false when the config
+// requires changes to be conditional and r has no If-Match header.
+func ifMatchGiven(w http.ResponseWriter, r *http.Request, config *internal.Config) bool {
+	if config.RequireIfMatch && r.Header.Get("If-Match") == "" {
+		httputil.Error(w, r, http.StatusPreconditionRequired, "the If-Match header is required")
+		return false
+	}
+	return true
+}
+
+// manageableBy reports if the principal can view and change the redirection,
+// which is limited to its owner and admins.
+func (r *Redirection) manageableBy(principal *auth.Principal) bool {
+	return principal.Role.Includes(auth.RoleAdmin) || (r.Owner != "" && r.Owner == principal.ID())
+}
+
+// etag returns the entity tag of this version of the redirection. The version
+// alone isn't enough, a redirection created again with the key of a purged one
+// starts at the same versions, so it includes when the redirection was created.
+func (r *Redirection) etag() string {
+	return fmt.Sprintf(`"%x-%d"`, r.CreatedAt.UnixNano(), r.Version)
+}
+
+// status returns the HTTP status code to redirect with.
+func (r *Redirection) status(config *internal.Config) int {
+	if r.Status != nil {
+		return *r.Status
+	}
+	return config.DefaultRedirectStatus
+}
+
+// expired reports if the redirection is expired at the given time.
+func (r *Redirection) expired(now time.Time) bool {
+	return r.ExpiresAt != nil && !now.Before(*r.ExpiresAt)
 }
 
 func Redirections(ctx context.Context, mux *http.ServeMux, deps *internal.Dependencies) error {
 	db := deps.DB
+	if !slices.Contains(redirectStatuses, deps.Config.DefaultRedirectStatus) {
+		return fmt.Errorf("invalid default redirect status %d", deps.Config.DefaultRedirectStatus)
+	}
+	keys := &keyPolicy{config: deps.Config, mux: mux}
+	lookups := newRedirectCache(deps)
+	deps.OnSweep(sweepRedirections(deps, lookups))
 
 	mux.HandleFunc("POST /redirections", func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		logger := logging.GetLogger(ctx)
+		namespace, ok := requestNamespace(w, r)
+		if !ok {
+			return
+		}
 		request := &CreateRequest{}
-		if err := json.NewDecoder(r.Body).Decode(request); err != nil {
-			w.WriteHeader(http.StatusBadRequest)
+		if !httputil.DecodeJSON(w, r, request) {
 			return
 		}
 		logger.Debug("creating redirection", "key", request.Key, "url", request.URL)
 
-		if request.Key == "" || request.URL == "" {
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte(`{"error": "key and url are required"}`)) //nolint:errcheck
+		if problem := request.validate(ctx, deps.Config, keys); problem != nil {
+			httputil.WriteProblem(w, r, problem)
 			return
 		}
 
-		now := timeutil.Now(ctx)
-		if _, err := db.ExecContext(ctx, "INSERT INTO redirection (key, url, created_at, updated_at) VALUES (?, ?, ?, ?)", request.Key, request.URL, now, now); err != nil {
+		redirection := &Redirection{
+			Namespace:       namespace,
+			Key:             request.Key,
+			URL:             request.URL,
+			Status:          request.Status,
+			ExpiresAt:       request.ExpiresAt,
+			ForwardPath:     request.ForwardPath,
+			ForwardQuery:    request.ForwardQuery,
+			QueryPrecedence: request.QueryPrecedence,
+			UTM:             request.UTM,
+			Owner:           auth.GetPrincipal(ctx).ID(),
+		}
+		err := sqlutil.InTx(ctx, db, func(tx *sql.Tx) error {
+			var err error
+			if redirection.Key != "" {
+				err = insertRedirection(ctx, tx, deps.Config, redirection)
+			} else {
+				err = insertWithGeneratedKey(ctx, tx, keys, redirection)
+			}
+			if err != nil {
+				return err
+			}
+			if redirection, err = getRedirection(ctx, tx, namespace, redirection.Key); err != nil {
+				return err
+			}
+			return recordAudit(ctx, tx, auditCreate, nil, redirection)
+		})
+		if err != nil {
+			if sqlutil.IsUniqueViolation(err) {
+				httputil.WriteProblem(w, r, httputil.NewProblem(http.StatusConflict, "a redirection with this key already exists").WithFieldError("key", "already exists"))
+				return
+			}
+			if err == errQuotaExceeded {
+				httputil.Error(w, r, http.StatusForbidden, "the quota of namespace "+namespace+" is exceeded")
+				return
+			}
 			logger.Error("failed to create redirection", "err", err)
-			w.WriteHeader(http.StatusInternalServerError)
+			httputil.InternalError(w, r)
+			return
+		}
+		lookups.forget(namespace, redirection.Key)
+		w.Header().Set("Location", "/redirections/"+url.PathEscape(redirection.Key))
+		w.Header().Set("ETag", redirection.etag())
+		httputil.WriteJSON(w, http.StatusCreated, redirection)
+
+		logger.Info("created redirection", "namespace", namespace, "key", redirection.Key, "url", redirection.URL)
+	})
+
+	mux.HandleFunc("GET /redirections", listRedirections(db))
+	mux.HandleFunc("POST /redirections:import", importRedirections(deps, keys, lookups))
+	mux.HandleFunc("GET /redirections:export", exportRedirections(db))
+	mux.HandleFunc("POST /redirections/batch", batchRedirections(deps, keys, lookups))
+
+	mux.HandleFunc("GET /redirections/{key}", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := logging.GetLogger(ctx)
+		key := r.PathValue("key")
+		namespace, ok := requestNamespace(w, r)
+		if !ok {
+			return
+		}
+
+		redirection, err := getRedirection(ctx, db, namespace, key)
+		if err != nil {
+			logger.Error("failed to query redirection", "err", err)
+			httputil.InternalError(w, r)
+			return
+		}
+		if redirection == nil {
+			httputil.Error(w, r, http.StatusNotFound, "redirection not found")
+			return
+		}
+		if !redirection.manageableBy(auth.GetPrincipal(ctx)) {
+			httputil.Error(w, r, http.StatusForbidden, errNotOwner)
+			return
+		}
+		w.Header().Set("ETag", redirection.etag())
+		if httputil.NotModified(r, redirection.etag()) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		httputil.WriteJSON(w, http.StatusOK, redirection)
+	})
+
+	mux.HandleFunc("GET /redirections/{key}/stats", redirectionStats(db))
+
+	mux.HandleFunc("PUT /redirections/{key}", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := logging.GetLogger(ctx)
+		key := r.PathValue("key")
+		namespace, ok := requestNamespace(w, r)
+		if !ok || !ifMatchGiven(w, r, deps.Config) {
+			return
+		}
+		request := &UpdateRequest{}
+		if !httputil.DecodeJSON(w, r, request) {
+			return
+		}
+
+		if problem := request.validate(ctx, deps.Config); problem != nil {
+			httputil.WriteProblem(w, r, problem)
+			return
+		}
+
+		principal := auth.GetPrincipal(ctx)
+		var existing, redirection *Redirection
+		err := sqlutil.InTx(ctx, db, func(tx *sql.Tx) error {
+			var err error
+			existing, err = getRedirection(ctx, tx, namespace, key)
+			if err != nil || existing == nil || !existing.manageableBy(principal) || !httputil.IfMatch(r, existing.etag()) {
+				return err
+			}
+			redirection, err = updateRedirection(ctx, tx, &Redirection{
+				Namespace:       namespace,
+				Key:             key,
+				URL:             request.URL,
+				Status:          request.Status,
+				ExpiresAt:       request.ExpiresAt,
+				ForwardPath:     request.ForwardPath,
+				ForwardQuery:    request.ForwardQuery,
+				QueryPrecedence: request.QueryPrecedence,
+				UTM:             request.UTM,
+			})
+			if err != nil {
+				return err
+			}
+			return recordAudit(ctx, tx, auditUpdate, existing, redirection)
+		})
+		if err != nil {
+			logger.Error("failed to update redirection", "err", err)
+			httputil.InternalError(w, r)
+			return
+		}
+		if existing == nil {
+			httputil.Error(w, r, http.StatusNotFound, "redirection not found")
+			return
+		}
+		if !existing.manageableBy(principal) {
+			httputil.Error(w, r, http.StatusForbidden, errNotOwner)
+			return
+		}
+		if !httputil.IfMatch(r, existing.etag()) {
+			httputil.Error(w, r, http.StatusPreconditionFailed, errChanged)
 			return
 		}
+		lookups.forget(namespace, key)
+		w.Header().Set("ETag", redirection.etag())
+		httputil.WriteJSON(w, http.StatusOK, redirection)
 
-		logger.Info("created redirection", "key", request.Key, "url", request.URL)
+		logger.Info("updated redirection", "key", key, "url", redirection.URL)
 	})
 
-	mux.HandleFunc("GET /{key}", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("PATCH /redirections/{key}", func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		logger := logging.GetLogger(ctx)
 		key := r.PathValue("key")
+		namespace, ok := requestNamespace(w, r)
+		if !ok || !ifMatchGiven(w, r, deps.Config) {
+			return
+		}
+		request := &PatchRequest{}
+		if !httputil.DecodeJSON(w, r, request) {
+			return
+		}
+		if problem := request.validate(ctx, deps.Config); problem != nil {
+			httputil.WriteProblem(w, r, problem)
+			return
+		}
+
+		principal := auth.GetPrincipal(ctx)
+		var existing, redirection *Redirection
+		err := sqlutil.InTx(ctx, db, func(tx *sql.Tx) error {
+			var err error
+			existing, err = getRedirection(ctx, tx, namespace, key)
+			if err != nil || existing == nil || !existing.manageableBy(principal) || !httputil.IfMatch(r, existing.etag()) {
+				return err
+			}
+			patched := *existing
+			request.apply(&patched)
+			if redirection, err = updateRedirection(ctx, tx, &patched); err != nil {
+				return err
+			}
+			return recordAudit(ctx, tx, auditUpdate, existing, redirection)
+		})
+		if err != nil {
+			logger.Error("failed to update redirection", "err", err)
+			httputil.InternalError(w, r)
+			return
+		}
+		if existing == nil {
+			httputil.Error(w, r, http.StatusNotFound, "redirection not found")
+			return
+		}
+		if !existing.manageableBy(principal) {
+			httputil.Error(w, r, http.StatusForbidden, errNotOwner)
+			return
+		}
+		if !httputil.IfMatch(r, existing.etag()) {
+			httputil.Error(w, r, http.StatusPreconditionFailed, errChanged)
+			return
+		}
+		lookups.forget(namespace, key)
+		w.Header().Set("ETag", redirection.etag())
+		httputil.WriteJSON(w, http.StatusOK, redirection)
+
+		logger.Info("patched redirection", "key", key, "url", redirection.URL)
+	})
+
+	// redirect serves both the redirectPattern and the pattern with the rest of
+	// the path, which only matches redirections that forward their path. Paths
+	// without a matching redirection are resolved by the rules.
+	redirect := func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := logging.GetLogger(ctx)
+		scope, err := resolveRedirect(ctx, db, lookups, deps.Config, r)
+		if err != nil {
+			logger.Error("failed to resolve redirect", "err", err)
+			httputil.InternalError(w, r)
+			return
+		}
+		namespace, key, rest := scope.namespace, scope.key, scope.rest
 
 		if key == "" {
-			w.WriteHeader(http.StatusBadRequest)
+			httputil.Error(w, r, http.StatusBadRequest, "key is required")
 			return
 		}
 
-		row := db.QueryRow("SELECT url FROM redirection WHERE key = ?", key)
-		var url string
-		if err := row.Scan(&url); err != nil && err != sql.ErrNoRows {
+		redirection, err := lookups.get(ctx, db, namespace, key)
+		if err != nil {
 			logger.Error("failed to query redirection", "err", err)
-			w.WriteHeader(http.StatusInternalServerError)
+			httputil.InternalError(w, r)
 			return
 		}
-		if url == "" {
-			w.WriteHeader(http.StatusNotFound)
+		if redirection == nil || (rest != "" && !redirection.ForwardPath) {
+			redirectByRule(w, r, deps, scope)
 			return
 		}
-		w.Header().Set("Location", url)
-		w.Header().Set("Content-Type", "text/html")
-		w.WriteHeader(http.StatusFound)
-		w.Write([]byte(`<script type=\"text/javascript\">window.location = "` + url + `";</script>`)) //nolint:errcheck
-	})
+		if redirection.expired(timeutil.Now(ctx)) {
+			httputil.Error(w, r, http.StatusGone, "redirection has expired")
+			return
+		}
+		target, err := redirection.targetURL(r, rest)
+		if errors.Is(err, errDotSegment) {
+			httputil.Error(w, r, http.StatusBadRequest, "the path must not have . or .. segments")
+			return
+		}
+		if err != nil {
+			logger.Error("failed to build target url", "err", err, "key", key)
+			httputil.InternalError(w, r)
+			return
+		}
+		deps.Hits.Record(hits.New(r, namespace, key))
+		writeRedirect(w, r, target, redirection.status(deps.Config))
+	}
+	mux.HandleFunc(redirectPattern, redirect)
+	mux.HandleFunc("GET /{key}/{rest...}", redirect)
+	deps.Public(redirectPattern, "GET /{key}/{rest...}")
 
 	mux.HandleFunc("DELETE /redirections/{key}", func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		logger := logging.GetLogger(ctx)
 		key := r.PathValue("key")
+		namespace, ok := requestNamespace(w, r)
+		if !ok || !ifMatchGiven(w, r, deps.Config) {
+			return
+		}
 
 		if key == "" {
-			w.WriteHeader(http.StatusBadRequest)
+			httputil.Error(w, r, http.StatusBadRequest, "key is required")
 			return
 		}
 
-		if _, err := db.ExecContext(ctx, "DELETE FROM redirection WHERE key = ?", key); err != nil {
+		principal := auth.GetPrincipal(ctx)
+		var existing *Redirection
+		err := sqlutil.InTx(ctx, db, func(tx *sql.Tx) error {
+			var err error
+			existing, err = getRedirection(ctx, tx, namespace, key)
+			if err != nil || existing == nil || !existing.manageableBy(principal) || !httputil.IfMatch(r, existing.etag()) {
+				return err
+			}
+			if err := deleteRedirection(ctx, tx, namespace, key); err != nil {
+				return err
+			}
+			return recordAudit(ctx, tx, auditDelete, existing, nil)
+		})
+		if err != nil {
 			logger.Error("failed to delete redirection", "err", err)
-			w.WriteHeader(http.StatusInternalServerError)
+			httputil.InternalError(w, r)
 			return
 		}
+		if existing != nil && !existing.manageableBy(principal) {
+			httputil.Error(w, r, http.StatusForbidden, errNotOwner)
+			return
+		}
+		// A missing redirection has no etag, so it fails any If-Match.
+		var etag string
+		if existing != nil {
+			etag = existing.etag()
+		}
+		if !httputil.IfMatch(r, etag) {
+			httputil.Error(w, r, http.StatusPreconditionFailed, errChanged)
+			return
+		}
+		lookups.forget(namespace, key)
 		w.WriteHeader(http.StatusNoContent)
 
-		logger.Info("deleted redirection", "key", key)
+		logger.Info("deleted redirection", "namespace", namespace, "key", key)
+	})
+
+	mux.HandleFunc("POST /redirections/{key}/restore", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := logging.GetLogger(ctx)
+		key := r.PathValue("key")
+		namespace, ok := requestNamespace(w, r)
+		if !ok {
+			return
+		}
+
+		principal := auth.GetPrincipal(ctx)
+		var deleted, redirection *Redirection
+		err := sqlutil.InTx(ctx, db, func(tx *sql.Tx) error {
+			var err error
+			deleted, err = getDeletedRedirection(ctx, tx, namespace, key)
+			if err != nil || deleted == nil || !deleted.manageableBy(principal) {
+				return err
+			}
+			if redirection, err = restoreRedirection(ctx, tx, deps.Config, namespace, key); err != nil {
+				return err
+			}
+			return recordAudit(ctx, tx, auditRestore, deleted, redirection)
+		})
+		if err == errQuotaExceeded {
+			httputil.Error(w, r, http.StatusForbidden, "the quota of namespace "+namespace+" is exceeded")
+			return
+		}
+		if err != nil {
+			logger.Error("failed to restore redirection", "err", err)
+			httputil.InternalError(w, r)
+			return
+		}
+		if deleted == nil {
+			httputil.Error(w, r, http.StatusNotFound, "deleted redirection not found")
+			return
+		}
+		if !deleted.manageableBy(principal) {
+			httputil.Error(w, r, http.StatusForbidden, errNotOwner)
+			return
+		}
+		lookups.forget(namespace, key)
+		w.Header().Set("ETag", redirection.etag())
+		httputil.WriteJSON(w, http.StatusOK, redirection)
+
+		logger.Info("restored redirection", "namespace", namespace, "key", key)
 	})
 
+	deps.Require(auth.RoleViewer, "GET /redirections", "GET /redirections:export", "GET /redirections/{key}", "GET /redirections/{key}/stats")
+	deps.Require(auth.RoleEditor, "POST /redirections", "POST /redirections:import", "POST /redirections/batch", "PUT /redirections/{key}", "PATCH /redirections/{key}", "DELETE /redirections/{key}", "POST /redirections/{key}/restore")
+	// Imports aren't idempotent, their bodies are too large to keep.
+	deps.Idempotent("POST /redirections", "POST /redirections/batch", "PUT /redirections/{key}", "PATCH /redirections/{key}", "DELETE /redirections/{key}", "POST /redirections/{key}/restore")
 	return nil
 }
@@ -0,0 +1,293 @@
+package routes
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/auth"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/rules"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/util/httputil"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/util/sqlutil"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/util/timeutil"
+	"github.com/koenbollen/logging"
+)
+
+// RuleRequest is used to create (POST) or replace (PUT) a rule.
+type RuleRequest struct {
+	Kind     string `json:"kind"`
+	Pattern  string `json:"pattern"`
+	Target   string `json:"target"`
+	Priority int    `json:"priority"`
+	Status   *int   `json:"status"`
+}
+
+// RuleListResponse are all rules, in the order they are matched.
+type RuleListResponse struct {
+	Items []*rules.Rule `json:"items"`
+}
+
+func (req *RuleRequest) validate(config *internal.Config) *httputil.Problem {
+	problem := httputil.NewProblem(http.StatusBadRequest, "the rule is invalid")
+	if !slices.Contains(rules.Kinds, req.Kind) {
+		problem.WithFieldError("kind", "must be one of "+strings.Join(rules.Kinds, ", "))
+	}
+	re, err := rules.Compile(req.Kind, req.Pattern)
+	if err != nil && req.Pattern == "" {
+		problem.WithFieldError("pattern", "is required")
+	} else if err != nil && slices.Contains(rules.Kinds, req.Kind) {
+		problem.WithFieldError("pattern", err.Error())
+	}
+	if req.Target == "" {
+		problem.WithFieldError("target", "is required")
+	} else if _, msg := normalizeURL(config, rules.Placeholder(req.Target)); msg != "" {
+		problem.WithFieldError("target", msg)
+	} else if re != nil {
+		if err := rules.CheckTarget(re, req.Target); err != nil {
+			problem.WithFieldError("target", err.Error())
+		}
+	}
+	validateStatus(problem, req.Status)
+	if problem.HasErrors() {
+		return problem
+	}
+	return nil
+}
+
+// Rules allows clients to manage the rules that redirect paths that don't
+// match a redirection key. The rules in deps.Rules are reloaded after every
+// change.
+func Rules(ctx context.Context, mux *http.ServeMux, deps *internal.Dependencies) error {
+	db := deps.DB
+
+	// reload loads the changed rules into the matcher, it responds with an
+	// error and returns false when that fails.
+	reload := func(w http.ResponseWriter, r *http.Request) bool {
+		if err := deps.Rules.Load(r.Context()); err != nil {
+			logging.GetLogger(r.Context()).Error("failed to reload rules", "err", err)
+			httputil.InternalError(w, r)
+			return false
+		}
+		return true
+	}
+
+	mux.HandleFunc("POST /rules", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := logging.GetLogger(ctx)
+		request := &RuleRequest{}
+		if !httputil.DecodeJSON(w, r, request) {
+			return
+		}
+		if problem := request.validate(deps.Config); problem != nil {
+			httputil.WriteProblem(w, r, problem)
+			return
+		}
+
+		now := timeutil.Now(ctx)
+		result, err := db.ExecContext(ctx, "INSERT INTO rule (kind, pattern, target, priority, status, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			request.Kind, request.Pattern, request.Target, request.Priority, request.Status, now, now)
+		if sqlutil.IsUniqueViolation(err) {
+			httputil.WriteProblem(w, r, httputil.NewProblem(http.StatusConflict, "a rule with this pattern already exists").WithFieldError("pattern", "already exists"))
+			return
+		}
+		var id int64
+		if err == nil {
+			id, err = result.LastInsertId()
+		}
+		if err != nil {
+			logger.Error("failed to create rule", "err", err)
+			httputil.InternalError(w, r)
+			return
+		}
+		if !reload(w, r) {
+			return
+		}
+
+		rule, err := getRule(ctx, db, id)
+		if err != nil || rule == nil {
+			logger.Error("failed to query created rule", "err", err)
+			httputil.InternalError(w, r)
+			return
+		}
+		w.Header().Set("Location", fmt.Sprintf("/rules/%d", rule.ID))
+		httputil.WriteJSON(w, http.StatusCreated, rule)
+
+		logger.Info("created rule", "id", rule.ID, "kind", rule.Kind, "pattern", rule.Pattern)
+	})
+
+	mux.HandleFunc("GET /rules", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := logging.GetLogger(ctx)
+
+		rows, err := db.QueryContext(ctx, "SELECT "+rules.Columns+" FROM rule ORDER BY priority DESC, length(pattern) DESC, id")
+		if err != nil {
+			logger.Error("failed to query rules", "err", err)
+			httputil.InternalError(w, r)
+			return
+		}
+		defer rows.Close()
+		response := &RuleListResponse{Items: []*rules.Rule{}}
+		for rows.Next() {
+			rule, err := rules.Scan(rows)
+			if err != nil {
+				logger.Error("failed to scan rule", "err", err)
+				httputil.InternalError(w, r)
+				return
+			}
+			response.Items = append(response.Items, rule)
+		}
+		if err := rows.Err(); err != nil {
+			logger.Error("failed to query rules", "err", err)
+			httputil.InternalError(w, r)
+			return
+		}
+		httputil.WriteJSON(w, http.StatusOK, response)
+	})
+
+	mux.HandleFunc("GET /rules/{id}", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := logging.GetLogger(ctx)
+
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			httputil.Error(w, r, http.StatusNotFound, "rule not found")
+			return
+		}
+		rule, err := getRule(ctx, db, id)
+		if err != nil {
+			logger.Error("failed to query rule", "err", err)
+			httputil.InternalError(w, r)
+			return
+		}
+		if rule == nil {
+			httputil.Error(w, r, http.StatusNotFound, "rule not found")
+			return
+		}
+		httputil.WriteJSON(w, http.StatusOK, rule)
+	})
+
+	mux.HandleFunc("PUT /rules/{id}", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := logging.GetLogger(ctx)
+
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			httputil.Error(w, r, http.StatusNotFound, "rule not found")
+			return
+		}
+		request := &RuleRequest{}
+		if !httputil.DecodeJSON(w, r, request) {
+			return
+		}
+		if problem := request.validate(deps.Config); problem != nil {
+			httputil.WriteProblem(w, r, problem)
+			return
+		}
+
+		result, err := db.ExecContext(ctx, "UPDATE rule SET kind = ?, pattern = ?, target = ?, priority = ?, status = ?, updated_at = ? WHERE id = ?",
+			request.Kind, request.Pattern, request.Target, request.Priority, request.Status, timeutil.Now(ctx), id)
+		if sqlutil.IsUniqueViolation(err) {
+			httputil.WriteProblem(w, r, httputil.NewProblem(http.StatusConflict, "a rule with this pattern already exists").WithFieldError("pattern", "already exists"))
+			return
+		}
+		var n int64
+		if err == nil {
+			n, err = result.RowsAffected()
+		}
+		if err != nil {
+			logger.Error("failed to update rule", "err", err)
+			httputil.InternalError(w, r)
+			return
+		}
+		if n == 0 {
+			httputil.Error(w, r, http.StatusNotFound, "rule not found")
+			return
+		}
+		if !reload(w, r) {
+			return
+		}
+
+		rule, err := getRule(ctx, db, id)
+		if err != nil || rule == nil {
+			logger.Error("failed to query updated rule", "err", err)
+			httputil.InternalError(w, r)
+			return
+		}
+		httputil.WriteJSON(w, http.StatusOK, rule)
+
+		logger.Info("updated rule", "id", rule.ID, "kind", rule.Kind, "pattern", rule.Pattern)
+	})
+
+	mux.HandleFunc("DELETE /rules/{id}", func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := logging.GetLogger(ctx)
+
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			httputil.Error(w, r, http.StatusNotFound, "rule not found")
+			return
+		}
+		if _, err := db.ExecContext(ctx, "DELETE FROM rule WHERE id = ?", id); err != nil {
+			logger.Error("failed to delete rule", "err", err)
+			httputil.InternalError(w, r)
+			return
+		}
+		if !reload(w, r) {
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+		logger.Info("deleted rule", "id", id)
+	})
+
+	deps.Require(auth.RoleViewer, "GET /rules", "GET /rules/{id}")
+	deps.Require(auth.RoleAdmin, "POST /rules", "PUT /rules/{id}", "DELETE /rules/{id}")
+	deps.Idempotent("POST /rules", "PUT /rules/{id}", "DELETE /rules/{id}")
+	return nil
+}
+
+// redirectByRule redirects the request with the first rule that matches the
+// key and rest of its path. Rules are shared by all namespaces and hits are
+// only recorded for redirections, not for rules.
+func redirectByRule(w http.ResponseWriter, r *http.Request, deps *internal.Dependencies, scope *redirectScope) {
+	logger := logging.GetLogger(r.Context())
+	path := scope.key
+	if scope.rest != "" {
+		path += "/" + scope.rest
+	}
+
+	rule, target := deps.Rules.Match(path)
+	if rule == nil {
+		scope.notFound(w, r)
+		return
+	}
+	// The captures come from the request, so the expanded target has to pass
+	// the URL policy again.
+	target, msg := normalizeURL(deps.Config, target)
+	if msg != "" {
+		logger.Warn("rule expanded to a disallowed url", "id", rule.ID, "path", path, "reason", msg)
+		scope.notFound(w, r)
+		return
+	}
+	status := deps.Config.DefaultRedirectStatus
+	if rule.Status != nil {
+		status = *rule.Status
+	}
+	writeRedirect(w, r, target, status)
+}
+
+// getRule fetches a single rule by id, it returns nil if the rule does not
+// exist.
+func getRule(ctx context.Context, db *sql.DB, id int64) (*rules.Rule, error) {
+	row := db.QueryRowContext(ctx, "SELECT "+rules.Columns+" FROM rule WHERE id = ?", id)
+	rule, err := rules.Scan(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return rule, err
+}
@@ -0,0 +1,187 @@
+package routes
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/auth"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/util/httputil"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/util/timeutil"
+	"github.com/koenbollen/logging"
+)
+
+// maxStatsBuckets limits the size of a stats response.
+const maxStatsBuckets = 1000
+
+// statsBuckets are the supported bucket sizes, with the strftime format that
+// truncates a timestamp to the start of its bucket.
+var statsBuckets = map[string]struct {
+	format   string
+	truncate func(time.Time) time.Time
+	next     func(time.Time) time.Time
+}{
+	"hour": {
+		format:   "%Y-%m-%dT%H:00:00Z",
+		truncate: func(t time.Time) time.Time { return t.UTC().Truncate(time.Hour) },
+		next:     func(t time.Time) time.Time { return t.Add(time.Hour) },
+	},
+	"day": {
+		format: "%Y-%m-%dT00:00:00Z",
+		truncate: func(t time.Time) time.Time {
+			y, m, d := t.UTC().Date()
+			return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+		},
+		next: func(t time.Time) time.Time { return t.AddDate(0, 0, 1) },
+	},
+}
+
+// StatsResponse are the hit statistics of a redirection.
+type StatsResponse struct {
+	Key     string        `json:"key"`
+	Total   int           `json:"total"`
+	From    time.Time     `json:"from"`
+	To      time.Time     `json:"to"`
+	Bucket  string        `json:"bucket"`
+	Count   int           `json:"count"`
+	Buckets []StatsBucket `json:"buckets"`
+}
+
+// StatsBucket is the number of hits in the period starting at Start.
+type StatsBucket struct {
+	Start time.Time `json:"start"`
+	Count int       `json:"count"`
+}
+
+// parseStatsQuery reads the bucket size and range of the requested stats, by
+// default the daily hits of the last week.
+func parseStatsQuery(ctx context.Context, q url.Values) (string, time.Time, time.Time, error) {
+	bucket := q.Get("bucket")
+	if bucket == "" {
+		bucket = "day"
+	}
+	if _, ok := statsBuckets[bucket]; !ok {
+		return "", time.Time{}, time.Time{}, fmt.Errorf("bucket must be hour or day")
+	}
+
+	to := timeutil.Now(ctx).UTC()
+	if v := q.Get("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return "", time.Time{}, time.Time{}, fmt.Errorf("to must be a RFC3339 timestamp")
+		}
+		to = t.UTC()
+	}
+	from := to.AddDate(0, 0, -7)
+	if v := q.Get("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return "", time.Time{}, time.Time{}, fmt.Errorf("from must be a RFC3339 timestamp")
+		}
+		from = t.UTC()
+	}
+	if !from.Before(to) {
+		return "", time.Time{}, time.Time{}, fmt.Errorf("from must be before to")
+	}
+	return bucket, from, to, nil
+}
+
+func redirectionStats(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		logger := logging.GetLogger(ctx)
+		key := r.PathValue("key")
+		namespace, ok := requestNamespace(w, r)
+		if !ok {
+			return
+		}
+
+		bucket, from, to, err := parseStatsQuery(ctx, r.URL.Query())
+		if err != nil {
+			httputil.Error(w, r, http.StatusBadRequest, err.Error())
+			return
+		}
+		size := statsBuckets[bucket]
+
+		response := &StatsResponse{
+			Key:     key,
+			From:    from,
+			To:      to,
+			Bucket:  bucket,
+			Buckets: []StatsBucket{},
+		}
+		index := map[time.Time]int{}
+		for start := size.truncate(from); start.Before(to); start = size.next(start) {
+			if len(response.Buckets) == maxStatsBuckets {
+				httputil.Error(w, r, http.StatusBadRequest, fmt.Sprintf("range can not contain more than %d buckets", maxStatsBuckets))
+				return
+			}
+			index[start] = len(response.Buckets)
+			response.Buckets = append(response.Buckets, StatsBucket{Start: start})
+		}
+
+		redirection, err := getRedirection(ctx, db, namespace, key)
+		if err != nil {
+			logger.Error("failed to query redirection", "err", err)
+			httputil.InternalError(w, r)
+			return
+		}
+		if redirection == nil {
+			httputil.Error(w, r, http.StatusNotFound, "redirection not found")
+			return
+		}
+		if !redirection.manageableBy(auth.GetPrincipal(ctx)) {
+			httputil.Error(w, r, http.StatusForbidden, errNotOwner)
+			return
+		}
+
+		row := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM hit WHERE namespace = ? AND key = ?", namespace, key)
+		if err := row.Scan(&response.Total); err != nil {
+			logger.Error("failed to count hits", "err", err)
+			httputil.InternalError(w, r)
+			return
+		}
+
+		rows, err := db.QueryContext(ctx, `
+			SELECT strftime(?, created_at) AS bucket, COUNT(*)
+			FROM hit
+			WHERE namespace = ? AND key = ? AND datetime(created_at) >= datetime(?) AND datetime(created_at) < datetime(?)
+			GROUP BY bucket
+		`, size.format, namespace, key, from.Format(time.DateTime), to.Format(time.DateTime))
+		if err != nil {
+			logger.Error("failed to query hits", "err", err)
+			httputil.InternalError(w, r)
+			return
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var start string
+			var count int
+			if err := rows.Scan(&start, &count); err != nil {
+				logger.Error("failed to scan hits", "err", err)
+				httputil.InternalError(w, r)
+				return
+			}
+			t, err := time.Parse(time.RFC3339, start)
+			if err != nil {
+				logger.Error("failed to parse bucket", "err", err, "bucket", start)
+				httputil.InternalError(w, r)
+				return
+			}
+			if i, ok := index[t]; ok {
+				response.Buckets[i].Count = count
+				response.Count += count
+			}
+		}
+		if err := rows.Err(); err != nil {
+			logger.Error("failed to query hits", "err", err)
+			httputil.InternalError(w, r)
+			return
+		}
+
+		httputil.WriteJSON(w, http.StatusOK, response)
+	}
+}
@@ -0,0 +1,271 @@
+package routes
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/auth"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/util/sqlutil"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/util/timeutil"
+	"github.com/koenbollen/logging"
+)
+
+// redirectionColumns are the columns scanned by scanRedirection, in order.
+const redirectionColumns = "namespace, key, url, status, forward_path, forward_query, query_precedence, utm, created_at, updated_at, expires_at, owner, deleted_at, version"
+
+// scanner is implemented by *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// scanRedirection scans the redirectionColumns (followed by the given extra
+// destinations) into a new Redirection.
+func scanRedirection(row scanner, extra ...any) (*Redirection, error) {
+	redirection := &Redirection{}
+	var status sql.NullInt64
+	var expiresAt sql.NullTime
+	var owner sql.NullString
+	var deletedAt sql.NullTime
+	dest := []any{
+		&redirection.Namespace, &redirection.Key, &redirection.URL, &status,
+		&redirection.ForwardPath, &redirection.ForwardQuery, &redirection.QueryPrecedence, &redirection.UTM,
+		&redirection.CreatedAt, &redirection.UpdatedAt, &expiresAt, &owner, &deletedAt, &redirection.Version,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	if status.Valid {
+		s := int(status.Int64)
+		redirection.Status = &s
+	}
+	if expiresAt.Valid {
+		redirection.ExpiresAt = &expiresAt.Time
+	}
+	redirection.Owner = owner.String
+	if deletedAt.Valid {
+		redirection.DeletedAt = &deletedAt.Time
+	}
+	return redirection, nil
+}
+
+// getRedirection fetches a single redirection by namespace and key, it
+// returns nil if the redirection does not exist or is deleted.
+func getRedirection(ctx context.Context, db sqlutil.Querier, namespace, key string) (*Redirection, error) {
+	row := db.QueryRowContext(ctx, "SELECT "+redirectionColumns+" FROM redirection WHERE namespace = ? AND key = ? AND deleted_at IS NULL", namespace, key)
+	redirection, err := scanRedirection(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return redirection, err
+}
+
+// getDeletedRedirection fetches a single deleted redirection by namespace and
+// key, it returns nil if there is no such deleted redirection.
+func getDeletedRedirection(ctx context.Context, db sqlutil.Querier, namespace, key string) (*Redirection, error) {
+	row := db.QueryRowContext(ctx, "SELECT "+redirectionColumns+" FROM redirection WHERE namespace = ? AND key = ? AND deleted_at IS NOT NULL", namespace, key)
+	redirection, err := scanRedirection(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return redirection, err
+}
+
+// insertRedirection inserts the redirection into its namespace, it returns
+// errQuotaExceeded when the namespace is full. A deleted redirection with the
+// same key is purged, and recorded as such in the audit log, when the caller
+// can manage it, otherwise its key stays taken until the sweeper purges it.
+func insertRedirection(ctx context.Context, db sqlutil.Querier, config *internal.Config, redirection *Redirection) error {
+	deleted, err := getDeletedRedirection(ctx, db, redirection.Namespace, redirection.Key)
+	if err != nil {
+		return err
+	}
+	if principal := auth.GetPrincipal(ctx); deleted != nil && principal != nil && deleted.manageableBy(principal) {
+		// Don't purge the deleted one when the new one can't be inserted.
+		if exceeded, err := quotaExceeded(ctx, db, config, redirection.Namespace); err != nil {
+			return err
+		} else if exceeded {
+			return errQuotaExceeded
+		}
+		if _, err := db.ExecContext(ctx, "DELETE FROM redirection WHERE namespace = ? AND key = ? AND deleted_at IS NOT NULL", redirection.Namespace, redirection.Key); err != nil {
+			return err
+		}
+		if err := recordAudit(ctx, db, auditPurge, deleted, nil); err != nil {
+			return err
+		}
+	}
+	return insertRow(ctx, db, config, redirection)
+}
+
+// insertRow inserts the redirection, it returns errQuotaExceeded when the
+// namespace is full. The quota is checked in the same statement so concurrent
+// inserts can't exceed it.
+func insertRow(ctx context.Context, db sqlutil.Querier, config *internal.Config, redirection *Redirection) error {
+	now := timeutil.Now(ctx)
+	result, err := db.ExecContext(ctx, `
+		INSERT INTO redirection (namespace, key, url, status, expires_at, forward_path, forward_query, query_precedence, utm, owner, created_at, updated_at)
+		SELECT ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?
+		FROM (SELECT COALESCE((SELECT max_redirections FROM namespace WHERE name = ?), ?) AS quota)
+		WHERE quota = 0 OR (SELECT COUNT(*) FROM redirection WHERE namespace = ? AND deleted_at IS NULL) < quota
+	`, redirection.Namespace, redirection.Key, redirection.URL, redirection.Status, redirection.ExpiresAt,
+		redirection.ForwardPath, redirection.ForwardQuery, redirection.QueryPrecedence, redirection.UTM, nullString(redirection.Owner), now, now,
+		redirection.Namespace, config.NamespaceQuota, redirection.Namespace)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errQuotaExceeded
+	}
+	return nil
+}
+
+// quotaExceeded reports if the namespace has no room for another redirection.
+func quotaExceeded(ctx context.Context, db sqlutil.Querier, config *internal.Config, namespace string) (bool, error) {
+	var quota, count int
+	err := db.QueryRowContext(ctx, `
+		SELECT COALESCE((SELECT max_redirections FROM namespace WHERE name = ?), ?),
+			(SELECT COUNT(*) FROM redirection WHERE namespace = ? AND deleted_at IS NULL)
+	`, namespace, config.NamespaceQuota, namespace).Scan(&quota, &count)
+	if err != nil {
+		return false, err
+	}
+	return quota > 0 && count >= quota, nil
+}
+
+// insertWithGeneratedKey inserts the redirection with a generated key, it
+// retries with a new key when the generated one is reserved or already taken,
+// also by a deleted redirection.
+func insertWithGeneratedKey(ctx context.Context, db sqlutil.Querier, keys *keyPolicy, redirection *Redirection) error {
+	var err error
+	for attempt := 0; attempt < maxGenerateAttempts; attempt++ {
+		if redirection.Key, err = keys.generate(ctx); err != nil {
+			return err
+		}
+		if keys.reserved(redirection.Key) {
+			err = fmt.Errorf("generated key %q is reserved", redirection.Key)
+			continue
+		}
+		if err = insertRow(ctx, db, keys.config, redirection); !sqlutil.IsUniqueViolation(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// updateRedirection stores the changeable fields of an existing redirection
+// as its next version and returns the updated record, it returns nil if the
+// redirection does not exist.
+func updateRedirection(ctx context.Context, db sqlutil.Querier, redirection *Redirection) (*Redirection, error) {
+	now := timeutil.Now(ctx)
+	result, err := db.ExecContext(ctx, `
+		UPDATE redirection
+		SET url = ?, status = ?, expires_at = ?, forward_path = ?, forward_query = ?, query_precedence = ?, utm = ?, updated_at = ?, version = version + 1
+		WHERE namespace = ? AND key = ? AND deleted_at IS NULL
+	`, redirection.URL, redirection.Status, redirection.ExpiresAt,
+		redirection.ForwardPath, redirection.ForwardQuery, redirection.QueryPrecedence, redirection.UTM, now,
+		redirection.Namespace, redirection.Key)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return nil, err
+	}
+	return getRedirection(ctx, db, redirection.Namespace, redirection.Key)
+}
+
+// deleteRedirection soft deletes the redirection, it's purged by Sweep after
+// the configured retention unless it's restored.
+func deleteRedirection(ctx context.Context, db sqlutil.Querier, namespace, key string) error {
+	_, err := db.ExecContext(ctx, "UPDATE redirection SET deleted_at = ?, version = version + 1 WHERE namespace = ? AND key = ? AND deleted_at IS NULL", timeutil.Now(ctx), namespace, key)
+	return err
+}
+
+// restoreRedirection restores a deleted redirection and returns it, it
+// returns errQuotaExceeded when the namespace has no room for it.
+func restoreRedirection(ctx context.Context, db sqlutil.Querier, config *internal.Config, namespace, key string) (*Redirection, error) {
+	if exceeded, err := quotaExceeded(ctx, db, config, namespace); err != nil {
+		return nil, err
+	} else if exceeded {
+		return nil, errQuotaExceeded
+	}
+
+	_, err := db.ExecContext(ctx, "UPDATE redirection SET deleted_at = NULL, updated_at = ?, version = version + 1 WHERE namespace = ? AND key = ? AND deleted_at IS NOT NULL", timeutil.Now(ctx), namespace, key)
+	if err != nil {
+		return nil, err
+	}
+	return getRedirection(ctx, db, namespace, key)
+}
+
+// sweepRedirections returns the OnSweep function that purges the
+// redirections that expired longer than ExpiredRetention ago, until then
+// they are answered with 410 Gone, and the ones deleted longer than
+// DeletedRetention ago, until then they can be restored.
+func sweepRedirections(deps *internal.Dependencies, lookups *redirectCache) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		logger := logging.GetLogger(ctx)
+		now := timeutil.Now(ctx)
+
+		cutoff := now.Add(-deps.Config.ExpiredRetention).UTC().Format(time.DateTime)
+		purged, err := purgeRedirections(ctx, deps.DB, lookups, `expires_at IS NOT NULL AND datetime(expires_at) <= datetime(?)`, cutoff)
+		if err != nil {
+			return err
+		}
+		if purged > 0 {
+			logger.Info("purged expired redirections", "count", purged)
+		}
+
+		cutoff = now.Add(-deps.Config.DeletedRetention).UTC().Format(time.DateTime)
+		purged, err = purgeRedirections(ctx, deps.DB, lookups, `deleted_at IS NOT NULL AND datetime(deleted_at) <= datetime(?)`, cutoff)
+		if err != nil {
+			return err
+		}
+		if purged > 0 {
+			logger.Info("purged deleted redirections", "count", purged)
+		}
+		return nil
+	}
+}
+
+// purgeRedirections deletes the redirections matching the condition, records
+// each of them in the audit log and forgets them in the cache, it returns how
+// many were purged.
+func purgeRedirections(ctx context.Context, db *sql.DB, lookups *redirectCache, condition string, args ...any) (int, error) {
+	var purged []*Redirection
+	err := sqlutil.InTx(ctx, db, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, "DELETE FROM redirection WHERE "+condition+" RETURNING "+redirectionColumns, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			redirection, err := scanRedirection(rows)
+			if err != nil {
+				return err
+			}
+			purged = append(purged, redirection)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if err := rows.Close(); err != nil {
+			return err
+		}
+		for _, redirection := range purged {
+			if err := recordAudit(ctx, tx, auditPurge, redirection, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, redirection := range purged {
+		lookups.forget(redirection.Namespace, redirection.Key)
+	}
+	return len(purged), nil
+}
@@ -0,0 +1,261 @@
+package routes
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/util/httputil"
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/util/timeutil"
+)
+
+// validationProblem is the problem returned when a request has field errors.
+func validationProblem() *httputil.Problem {
+	return httputil.NewProblem(http.StatusBadRequest, "the redirection is invalid")
+}
+
+// validateURL adds a field error to problem when the url is missing or not
+// allowed, otherwise it returns the normalized url.
+func validateURL(config *internal.Config, problem *httputil.Problem, raw string) string {
+	if raw == "" {
+		problem.WithFieldError("url", "is required")
+		return raw
+	}
+	normalized, msg := normalizeURL(config, raw)
+	if msg != "" {
+		problem.WithFieldError("url", msg)
+		return raw
+	}
+	return normalized
+}
+
+// validateExpiry adds a field error to problem when both or an invalid
+// expires_at and ttl are given, otherwise it returns the absolute expiry.
+func validateExpiry(ctx context.Context, problem *httputil.Problem, expiresAt *time.Time, ttl string) *time.Time {
+	now := timeutil.Now(ctx)
+	if ttl != "" {
+		if expiresAt != nil {
+			problem.WithFieldError("ttl", "can not be combined with expires_at")
+			return nil
+		}
+		d, err := time.ParseDuration(ttl)
+		if err != nil || d <= 0 {
+			problem.WithFieldError("ttl", "must be a positive duration, e.g. 24h")
+			return nil
+		}
+		t := now.Add(d).UTC()
+		return &t
+	}
+	if expiresAt != nil && !expiresAt.After(now) {
+		problem.WithFieldError("expires_at", "must be in the future")
+		return nil
+	}
+	return expiresAt
+}
+
+// redirectStatuses are the status codes a redirection can respond with.
+var redirectStatuses = []int{
+	http.StatusMovedPermanently,
+	http.StatusFound,
+	http.StatusSeeOther,
+	http.StatusTemporaryRedirect,
+	http.StatusPermanentRedirect,
+}
+
+// validateStatus adds a field error to problem when the status is given but
+// not a supported redirect status.
+func validateStatus(problem *httputil.Problem, status *int) {
+	if status != nil && !slices.Contains(redirectStatuses, *status) {
+		problem.WithFieldError("status", "must be one of 301, 302, 303, 307 or 308")
+	}
+}
+
+// validatePrecedence adds a field error to problem for an unknown query
+// precedence, an empty precedence is the default.
+func validatePrecedence(problem *httputil.Problem, precedence string) {
+	if precedence != "" && precedence != precedenceTarget && precedence != precedenceRequest {
+		problem.WithFieldError("query_precedence", "must be target or request")
+	}
+}
+
+// validateUTM adds a field error to problem for unknown or empty UTM
+// parameters.
+func validateUTM(problem *httputil.Problem, utm utmParams) {
+	names := make([]string, 0, len(utm))
+	for name := range utm {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		if !slices.Contains(utmNames, name) {
+			problem.WithFieldError("utm", fmt.Sprintf("%s is not one of %s", name, strings.Join(utmNames, ", ")))
+		} else if utm[name] == "" {
+			problem.WithFieldError("utm", fmt.Sprintf("%s can not be empty", name))
+		}
+	}
+}
+
+func (req *CreateRequest) validate(ctx context.Context, config *internal.Config, keys *keyPolicy) *httputil.Problem {
+	problem := validationProblem()
+	if req.Key != "" {
+		if msg := keys.validate(req.Key); msg != "" {
+			problem.WithFieldError("key", msg)
+		}
+	}
+	req.URL = validateURL(config, problem, req.URL)
+	req.ExpiresAt = validateExpiry(ctx, problem, req.ExpiresAt, req.TTL)
+	validateStatus(problem, req.Status)
+	validatePrecedence(problem, req.QueryPrecedence)
+	validateUTM(problem, req.UTM)
+	if problem.HasErrors() {
+		return problem
+	}
+	return nil
+}
+
+func (req *UpdateRequest) validate(ctx context.Context, config *internal.Config) *httputil.Problem {
+	problem := validationProblem()
+	req.URL = validateURL(config, problem, req.URL)
+	req.ExpiresAt = validateExpiry(ctx, problem, req.ExpiresAt, req.TTL)
+	validateStatus(problem, req.Status)
+	validatePrecedence(problem, req.QueryPrecedence)
+	validateUTM(problem, req.UTM)
+	if problem.HasErrors() {
+		return problem
+	}
+	return nil
+}
+
+func (req *PatchRequest) validate(ctx context.Context, config *internal.Config) *httputil.Problem {
+	problem := validationProblem()
+	if req.URL != nil {
+		normalized := validateURL(config, problem, *req.URL)
+		req.URL = &normalized
+	}
+	if req.TTL != nil {
+		req.ExpiresAt.Set = true
+		req.ExpiresAt.Value = validateExpiry(ctx, problem, req.ExpiresAt.Value, *req.TTL)
+	} else if req.ExpiresAt.Value != nil {
+		req.ExpiresAt.Value = validateExpiry(ctx, problem, req.ExpiresAt.Value, "")
+	}
+	validateStatus(problem, req.Status.Value)
+	if req.QueryPrecedence != nil {
+		validatePrecedence(problem, *req.QueryPrecedence)
+	}
+	if req.UTM.Value != nil {
+		validateUTM(problem, *req.UTM.Value)
+	}
+	if problem.HasErrors() {
+		return problem
+	}
+	return nil
+}
+
+// apply changes the fields of the redirection that are given in the request.
+func (req *PatchRequest) apply(redirection *Redirection) {
+	if req.URL != nil {
+		redirection.URL = *req.URL
+	}
+	if req.ExpiresAt.Set {
+		redirection.ExpiresAt = req.ExpiresAt.Value
+	}
+	if req.Status.Set {
+		redirection.Status = req.Status.Value
+	}
+	if req.ForwardPath != nil {
+		redirection.ForwardPath = *req.ForwardPath
+	}
+	if req.ForwardQuery != nil {
+		redirection.ForwardQuery = *req.ForwardQuery
+	}
+	if req.QueryPrecedence != nil {
+		redirection.QueryPrecedence = *req.QueryPrecedence
+	}
+	if req.UTM.Set {
+		redirection.UTM = nil
+		if req.UTM.Value != nil {
+			redirection.UTM = *req.UTM.Value
+		}
+	}
+}
+
+// defaultPorts are removed from URLs while normalizing.
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// normalizeURL parses and validates the given redirection target against the
+// scheme and host policy of the config. URLs without a host (e.g. mailto:) are
+// only accepted for configured schemes other than http and https. It returns
+// the normalized URL, or a message explaining why the URL is not allowed.
+func normalizeURL(config *internal.Config, raw string) (string, string) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "", "is not a valid URL"
+	}
+	if u.Scheme == "" {
+		return "", "must be an absolute URL"
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Opaque != "" {
+		// URLs without a host, e.g. mailto: or tel:, are only allowed for
+		// schemes that are explicitly allowed, and never for web URLs. The
+		// host policy doesn't apply to them.
+		if _, web := defaultPorts[u.Scheme]; web || !slices.Contains(config.AllowedSchemes, u.Scheme) {
+			return "", "must be an absolute URL"
+		}
+		return u.String(), ""
+	}
+	if u.Host == "" {
+		return "", "must be an absolute URL"
+	}
+	if u.User != nil {
+		return "", "can not contain credentials"
+	}
+
+	if !slices.Contains(config.AllowedSchemes, u.Scheme) {
+		return "", fmt.Sprintf("scheme %s is not allowed", u.Scheme)
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "" {
+		return "", "must have a host"
+	}
+	if matchesHost(config.DeniedHosts, host) {
+		return "", fmt.Sprintf("host %s is not allowed", host)
+	}
+	if len(config.AllowedHosts) > 0 && !matchesHost(config.AllowedHosts, host) {
+		return "", fmt.Sprintf("host %s is not allowed", host)
+	}
+
+	port := u.Port()
+	if port == defaultPorts[u.Scheme] {
+		port = ""
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	u.Host = host
+	if port != "" {
+		u.Host += ":" + port
+	}
+	return u.String(), ""
+}
+
+// matchesHost reports if host is one of the given hosts, or a subdomain of
+// one of them.
+func matchesHost(hosts []string, host string) bool {
+	for _, h := range hosts {
+		h = strings.ToLower(h)
+		if host == h || strings.HasSuffix(host, "."+h) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,101 @@
+package rules
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// compiled is a rule with its compiled pattern.
+type compiled struct {
+	*Rule
+	re *regexp.Regexp
+	// prefix is the literal text every matching path starts with, checked
+	// before running the regular expression.
+	prefix string
+}
+
+// Matcher finds the rule for a path. The rules are kept in memory and only
+// read from the database when Load is called, so a path is matched without
+// querying.
+type Matcher struct {
+	db    *sql.DB
+	rules atomic.Pointer[[]compiled]
+}
+
+// NewMatcher creates a Matcher without rules, call Load to read them.
+func NewMatcher(db *sql.DB) *Matcher {
+	m := &Matcher{db: db}
+	m.rules.Store(&[]compiled{})
+	return m
+}
+
+// Load reads all rules from the database and replaces the rules of the
+// matcher. It should be called whenever the rules change.
+func (m *Matcher) Load(ctx context.Context) error {
+	rows, err := m.db.QueryContext(ctx, "SELECT "+Columns+" FROM rule")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var rules []compiled
+	for rows.Next() {
+		rule, err := Scan(rows)
+		if err != nil {
+			return err
+		}
+		re, err := Compile(rule.Kind, rule.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern of rule %d: %w", rule.ID, err)
+		}
+		prefix, _ := re.LiteralPrefix()
+		rules = append(rules, compiled{Rule: rule, re: re, prefix: prefix})
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	slices.SortFunc(rules, func(a, b compiled) int {
+		return compare(a.Rule, b.Rule)
+	})
+	m.rules.Store(&rules)
+	return nil
+}
+
+// compare orders rules by their priority: highest priority first, then the
+// longest pattern and finally the oldest rule.
+func compare(a, b *Rule) int {
+	return cmp.Or(
+		cmp.Compare(b.Priority, a.Priority),
+		cmp.Compare(len(b.Pattern), len(a.Pattern)),
+		cmp.Compare(a.ID, b.ID),
+	)
+}
+
+// Match returns the first rule matching the path (without leading slash) and
+// its target with the captures substituted, or nil when no rule matches.
+func (m *Matcher) Match(path string) (*Rule, string) {
+	for _, rule := range *m.rules.Load() {
+		if !strings.HasPrefix(path, rule.prefix) {
+			continue
+		}
+		match := rule.re.FindStringSubmatchIndex(path)
+		if match == nil {
+			continue
+		}
+		target := rule.re.ExpandString(nil, rule.Target, path, match)
+		return rule.Rule, string(target)
+	}
+	return nil, ""
+}
+
+// Len returns the number of loaded rules.
+func (m *Matcher) Len() int {
+	return len(*m.rules.Load())
+}
@@ -0,0 +1,124 @@
+// rules resolves paths that don't match a redirection key exactly. A rule
+// matches a path by prefix, glob or regular expression and substitutes the
+// captured parts into its target URL template.
+package rules
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kinds of patterns a rule can have.
+const (
+	// KindPrefix matches paths starting with the pattern, the rest of the path
+	// is captured as $1.
+	KindPrefix = "prefix"
+	// KindGlob matches the whole path, each * captures any (possibly empty)
+	// text and each ? captures a single character.
+	KindGlob = "glob"
+	// KindRegex matches the whole path against a regular expression, its
+	// groups are captured.
+	KindRegex = "regex"
+)
+
+// Kinds are all supported kinds of patterns.
+var Kinds = []string{KindPrefix, KindGlob, KindRegex}
+
+// Columns are the columns scanned by Scan, in order.
+const Columns = "id, kind, pattern, target, priority, status, created_at, updated_at"
+
+// Rule redirects all paths matching its pattern to its target. Target can
+// refer to the captures with $1, ${1} or ${name} for named regex groups.
+type Rule struct {
+	ID        int64     `json:"id"`
+	Kind      string    `json:"kind"`
+	Pattern   string    `json:"pattern"`
+	Target    string    `json:"target"`
+	Priority  int       `json:"priority"`
+	Status    *int      `json:"status,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// scanner is implemented by *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// Scan scans the Columns into a new Rule.
+func Scan(row scanner) (*Rule, error) {
+	rule := &Rule{}
+	var status sql.NullInt64
+	if err := row.Scan(&rule.ID, &rule.Kind, &rule.Pattern, &rule.Target, &rule.Priority, &status, &rule.CreatedAt, &rule.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if status.Valid {
+		s := int(status.Int64)
+		rule.Status = &s
+	}
+	return rule, nil
+}
+
+// Compile turns the pattern of the given kind into an anchored regular
+// expression that matches paths without their leading slash.
+func Compile(kind, pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, fmt.Errorf("can not be empty")
+	}
+	switch kind {
+	case KindPrefix:
+		return regexp.Compile(`^` + regexp.QuoteMeta(pattern) + `(.*)$`)
+	case KindGlob:
+		var expr strings.Builder
+		expr.WriteString(`^`)
+		for _, r := range pattern {
+			switch r {
+			case '*':
+				expr.WriteString(`(.*?)`)
+			case '?':
+				expr.WriteString(`(.)`)
+			default:
+				expr.WriteString(regexp.QuoteMeta(string(r)))
+			}
+		}
+		expr.WriteString(`$`)
+		return regexp.Compile(expr.String())
+	case KindRegex:
+		re, err := regexp.Compile(`^(?:` + pattern + `)$`)
+		if err != nil {
+			return nil, fmt.Errorf("is not a valid regular expression")
+		}
+		return re, nil
+	}
+	return nil, fmt.Errorf("unknown kind %s", kind)
+}
+
+// references matches the captures a target refers to, as understood by
+// regexp.Expand.
+var references = regexp.MustCompile(`\$(?:\{(\w+)\}|(\w+))`)
+
+// CheckTarget returns an error when the target refers to a capture that the
+// pattern does not have.
+func CheckTarget(re *regexp.Regexp, target string) error {
+	for _, m := range references.FindAllStringSubmatch(target, -1) {
+		name := m[1] + m[2]
+		if n, err := strconv.Atoi(name); err == nil {
+			if n > re.NumSubexp() {
+				return fmt.Errorf("refers to $%d but the pattern has %d captures", n, re.NumSubexp())
+			}
+		} else if re.SubexpIndex(name) < 0 {
+			return fmt.Errorf("refers to unknown capture %s", name)
+		}
+	}
+	return nil
+}
+
+// Placeholder replaces the captures the target refers to with a placeholder,
+// so the target can be validated as URL.
+func Placeholder(target string) string {
+	return references.ReplaceAllString(target, "x")
+}
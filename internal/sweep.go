@@ -0,0 +1,54 @@
+package internal
+
+import (
+	"context"
+	"time"
+
+	"github.com/koenbollen/go-tested-api-with-sqlite/internal/util/timeutil"
+	"github.com/koenbollen/logging"
+)
+
+// Sweep removes data that is no longer needed: the data the routes purge in
+// their OnSweep functions, e.g. expired and deleted redirections, and expired
+// idempotency keys. Setup runs this periodically in the background.
+func Sweep(ctx context.Context, deps *Dependencies) error {
+	logger := logging.GetLogger(ctx)
+	now := timeutil.Now(ctx)
+
+	deps.sweepMu.Lock()
+	sweepers := deps.sweepers
+	deps.sweepMu.Unlock()
+	for _, fn := range sweepers {
+		if err := fn(ctx); err != nil {
+			return err
+		}
+	}
+
+	cutoff := now.Add(-deps.Config.IdempotencyKeyTTL).UTC().Format(time.DateTime)
+	result, err := deps.DB.ExecContext(ctx, `DELETE FROM idempotency_key WHERE datetime(created_at) <= datetime(?)`, cutoff)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		logger.Info("purged idempotency keys", "count", n)
+	}
+	return nil
+}
+
+// every calls fn every interval until the context is cancelled, an interval
+// of zero disables it.
+func every(ctx context.Context, interval time.Duration, fn func(context.Context)) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			fn(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package httputil
+
+import (
+	"net/http"
+	"strings"
+)
+
+// IfMatch reports if the If-Match header of r, when given, matches the
+// current entity tag of the resource. An empty etag is a resource that doesn't
+// exist, which matches nothing.
+func IfMatch(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-Match")
+	return header == "" || MatchETag(header, etag, false)
+}
+
+// NotModified reports if the If-None-Match header of r matches the current
+// entity tag of the resource, a GET can then respond with 304 Not Modified.
+func NotModified(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-None-Match")
+	return header != "" && MatchETag(header, etag, true)
+}
+
+// MatchETag reports if etag is in the comma separated list of entity tags or
+// the list is "*", see RFC 9110 section 13.1. The weak comparison ignores the
+// W/ prefixes, with the strong comparison weak tags never match.
+func MatchETag(list, etag string, weak bool) bool {
+	if etag == "" {
+		return false
+	}
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+	if weak {
+		etag = strings.TrimPrefix(etag, "W/")
+	} else if strings.HasPrefix(etag, "W/") {
+		return false
+	}
+	for _, tag := range strings.Split(list, ",") {
+		tag = strings.TrimSpace(tag)
+		if weak {
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+		if tag == etag {
+			return true
+		}
+	}
+	return false
+}
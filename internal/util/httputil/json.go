@@ -0,0 +1,24 @@
+package httputil
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// WriteJSON writes v as JSON response with the given status code.
+func WriteJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v) //nolint:errcheck
+}
+
+// DecodeJSON decodes the request body into v. When the body is invalid a 400
+// problem is written and false is returned, the caller should stop handling
+// the request.
+func DecodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		Error(w, r, http.StatusBadRequest, "request body must be valid JSON: "+err.Error())
+		return false
+	}
+	return true
+}
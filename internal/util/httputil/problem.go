@@ -0,0 +1,81 @@
+// httputil contains helpers to write consistent HTTP responses from routes.
+// Every error a route returns should be a Problem so clients only have to
+// understand a single error format.
+package httputil
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/koenbollen/logging"
+)
+
+// ProblemContentType is the media type of a Problem, see RFC 7807.
+const ProblemContentType = "application/problem+json"
+
+// FieldError describes why the value of a single request field is invalid.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Problem is an RFC 7807 problem details object, extended with field errors
+// and the id of the request that caused it.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+}
+
+// NewProblem creates a Problem for the given status code, the title is the
+// standard status text.
+func NewProblem(status int, detail string) *Problem {
+	return &Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+}
+
+// WithFieldError adds a validation error for the given field.
+func (p *Problem) WithFieldError(field, message string) *Problem {
+	p.Errors = append(p.Errors, FieldError{Field: field, Message: message})
+	return p
+}
+
+// HasErrors reports if any field errors were added.
+func (p *Problem) HasErrors() bool {
+	return len(p.Errors) > 0
+}
+
+// WriteProblem writes the given problem as response, annotated with the
+// request id of r.
+func WriteProblem(w http.ResponseWriter, r *http.Request, p *Problem) {
+	if p.RequestID == "" {
+		p.RequestID = logging.GetRequestID(r.Context())
+	}
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p) //nolint:errcheck
+}
+
+// Error writes a problem with the given status and detail message.
+func Error(w http.ResponseWriter, r *http.Request, status int, detail string) {
+	WriteProblem(w, r, NewProblem(status, detail))
+}
+
+// InternalError writes a generic 500 problem, details of the cause are never
+// exposed to the client and should be logged by the caller instead.
+func InternalError(w http.ResponseWriter, r *http.Request) {
+	WriteProblem(w, r, NewProblem(http.StatusInternalServerError, ""))
+}
+
+// NotFound is a http.HandlerFunc that writes a 404 problem, it's used for
+// requests that don't match any route.
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	Error(w, r, http.StatusNotFound, "")
+}
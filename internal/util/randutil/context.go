@@ -0,0 +1,25 @@
+package randutil
+
+import (
+	"context"
+	"crypto/rand"
+	"io"
+)
+
+type key int
+
+var readerKey key = 0
+
+// WithReader will attach the given source of random bytes to the context.
+func WithReader(ctx context.Context, r io.Reader) context.Context {
+	return context.WithValue(ctx, readerKey, r)
+}
+
+// Reader returns the source of random bytes of the context, crypto/rand by
+// default.
+func Reader(ctx context.Context) io.Reader {
+	if r, ok := ctx.Value(readerKey).(io.Reader); ok {
+		return r
+	}
+	return rand.Reader
+}
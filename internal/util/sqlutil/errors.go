@@ -0,0 +1,20 @@
+// sqlutil contains helpers for transactions and to interpret errors of the
+// sqlite driver.
+package sqlutil
+
+import (
+	"errors"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// IsUniqueViolation reports if err is caused by a PRIMARY KEY or UNIQUE
+// constraint, e.g. when inserting a row with a key that already exists.
+func IsUniqueViolation(err error) bool {
+	var e *sqlite.Error
+	if !errors.As(err, &e) {
+		return false
+	}
+	return e.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY || e.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE
+}
@@ -0,0 +1,29 @@
+package sqlutil
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Querier is implemented by both *sql.DB and *sql.Tx, so queries can run
+// either on their own or as part of a transaction.
+type Querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// InTx runs fn in a transaction, which is committed when fn returns nil and
+// rolled back otherwise. With a single connection (e.g. an in-memory database)
+// fn must only use tx, the database itself is blocked until fn returns.
+func InTx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
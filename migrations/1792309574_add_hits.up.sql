@@ -0,0 +1,9 @@
+CREATE TABLE "hit" (
+    "id" INTEGER PRIMARY KEY AUTOINCREMENT,
+    "key" TEXT NOT NULL,
+    "created_at" TIMESTAMP NOT NULL,
+    "referrer" TEXT,
+    "user_agent" TEXT,
+    "ip" TEXT
+);
+CREATE INDEX "hit_key_created_at" ON "hit" ("key", "created_at");
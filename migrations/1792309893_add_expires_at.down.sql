@@ -0,0 +1,2 @@
+DROP INDEX "redirection_expires_at";
+ALTER TABLE "redirection" DROP COLUMN "expires_at";
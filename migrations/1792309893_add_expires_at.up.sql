@@ -0,0 +1,2 @@
+ALTER TABLE "redirection" ADD COLUMN "expires_at" TIMESTAMP;
+CREATE INDEX "redirection_expires_at" ON "redirection" ("expires_at");
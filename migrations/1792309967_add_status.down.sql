@@ -0,0 +1 @@
+ALTER TABLE "redirection" DROP COLUMN "status";
@@ -0,0 +1 @@
+ALTER TABLE "redirection" ADD COLUMN "status" INTEGER;
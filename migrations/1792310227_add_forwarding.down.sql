@@ -0,0 +1,4 @@
+ALTER TABLE "redirection" DROP COLUMN "utm";
+ALTER TABLE "redirection" DROP COLUMN "query_precedence";
+ALTER TABLE "redirection" DROP COLUMN "forward_query";
+ALTER TABLE "redirection" DROP COLUMN "forward_path";
@@ -0,0 +1,4 @@
+ALTER TABLE "redirection" ADD COLUMN "forward_path" BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE "redirection" ADD COLUMN "forward_query" BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE "redirection" ADD COLUMN "query_precedence" TEXT NOT NULL DEFAULT '';
+ALTER TABLE "redirection" ADD COLUMN "utm" TEXT;
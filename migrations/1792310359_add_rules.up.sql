@@ -0,0 +1,11 @@
+CREATE TABLE "rule" (
+    "id" INTEGER PRIMARY KEY AUTOINCREMENT,
+    "kind" TEXT NOT NULL,
+    "pattern" TEXT NOT NULL,
+    "target" TEXT NOT NULL,
+    "priority" INTEGER NOT NULL DEFAULT 0,
+    "status" INTEGER,
+    "created_at" TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    "updated_at" TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE ("kind", "pattern")
+);
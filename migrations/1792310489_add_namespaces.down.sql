@@ -0,0 +1,25 @@
+DROP TABLE "namespace";
+
+DROP INDEX "hit_namespace_key_created_at";
+DELETE FROM "hit" WHERE "namespace" != 'default';
+ALTER TABLE "hit" DROP COLUMN "namespace";
+CREATE INDEX "hit_key_created_at" ON "hit" ("key", "created_at");
+
+CREATE TABLE "redirection_global" (
+    "key" TEXT PRIMARY KEY,
+    "url" TEXT NOT NULL,
+    "created_at" TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    "updated_at" TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    "expires_at" TIMESTAMP,
+    "status" INTEGER,
+    "forward_path" BOOLEAN NOT NULL DEFAULT FALSE,
+    "forward_query" BOOLEAN NOT NULL DEFAULT FALSE,
+    "query_precedence" TEXT NOT NULL DEFAULT '',
+    "utm" TEXT
+);
+INSERT INTO "redirection_global" ("key", "url", "created_at", "updated_at", "expires_at", "status", "forward_path", "forward_query", "query_precedence", "utm")
+SELECT "key", "url", "created_at", "updated_at", "expires_at", "status", "forward_path", "forward_query", "query_precedence", "utm" FROM "redirection" WHERE "namespace" = 'default';
+DROP INDEX "redirection_expires_at";
+DROP TABLE "redirection";
+ALTER TABLE "redirection_global" RENAME TO "redirection";
+CREATE INDEX "redirection_expires_at" ON "redirection" ("expires_at");
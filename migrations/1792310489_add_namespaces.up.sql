@@ -0,0 +1,31 @@
+CREATE TABLE "redirection_namespaced" (
+    "namespace" TEXT NOT NULL DEFAULT 'default',
+    "key" TEXT NOT NULL,
+    "url" TEXT NOT NULL,
+    "created_at" TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    "updated_at" TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    "expires_at" TIMESTAMP,
+    "status" INTEGER,
+    "forward_path" BOOLEAN NOT NULL DEFAULT FALSE,
+    "forward_query" BOOLEAN NOT NULL DEFAULT FALSE,
+    "query_precedence" TEXT NOT NULL DEFAULT '',
+    "utm" TEXT,
+    PRIMARY KEY ("namespace", "key")
+);
+INSERT INTO "redirection_namespaced" ("key", "url", "created_at", "updated_at", "expires_at", "status", "forward_path", "forward_query", "query_precedence", "utm")
+SELECT "key", "url", "created_at", "updated_at", "expires_at", "status", "forward_path", "forward_query", "query_precedence", "utm" FROM "redirection";
+DROP INDEX "redirection_expires_at";
+DROP TABLE "redirection";
+ALTER TABLE "redirection_namespaced" RENAME TO "redirection";
+CREATE INDEX "redirection_expires_at" ON "redirection" ("expires_at");
+
+ALTER TABLE "hit" ADD COLUMN "namespace" TEXT NOT NULL DEFAULT 'default';
+DROP INDEX "hit_key_created_at";
+CREATE INDEX "hit_namespace_key_created_at" ON "hit" ("namespace", "key", "created_at");
+
+CREATE TABLE "namespace" (
+    "name" TEXT PRIMARY KEY,
+    "max_redirections" INTEGER,
+    "created_at" TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    "updated_at" TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
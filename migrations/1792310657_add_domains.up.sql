@@ -0,0 +1,7 @@
+CREATE TABLE "domain" (
+    "host" TEXT PRIMARY KEY,
+    "namespace" TEXT NOT NULL DEFAULT 'default',
+    "not_found_url" TEXT,
+    "created_at" TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    "updated_at" TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
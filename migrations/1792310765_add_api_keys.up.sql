@@ -0,0 +1,9 @@
+CREATE TABLE "api_key" (
+    "id" INTEGER PRIMARY KEY AUTOINCREMENT,
+    "name" TEXT NOT NULL,
+    "prefix" TEXT NOT NULL,
+    "hash" TEXT NOT NULL UNIQUE,
+    "created_at" TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    "revoked_at" TIMESTAMP
+);
+CREATE UNIQUE INDEX "api_key_name" ON "api_key" ("name") WHERE "revoked_at" IS NULL;
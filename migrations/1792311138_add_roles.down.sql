@@ -0,0 +1,3 @@
+ALTER TABLE "api_key" DROP COLUMN "role";
+DROP INDEX "redirection_owner";
+ALTER TABLE "redirection" DROP COLUMN "owner";
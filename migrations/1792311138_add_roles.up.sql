@@ -0,0 +1,5 @@
+ALTER TABLE "redirection" ADD COLUMN "owner" TEXT;
+CREATE INDEX "redirection_owner" ON "redirection" ("namespace", "owner");
+
+-- Keys issued before roles existed had full access.
+ALTER TABLE "api_key" ADD COLUMN "role" TEXT NOT NULL DEFAULT 'admin';
@@ -0,0 +1,23 @@
+CREATE TABLE "audit" (
+    "id" INTEGER PRIMARY KEY AUTOINCREMENT,
+    "namespace" TEXT NOT NULL,
+    "key" TEXT NOT NULL,
+    "action" TEXT NOT NULL,
+    "actor" TEXT,
+    "before" TEXT,
+    "after" TEXT,
+    "request_id" TEXT,
+    "created_at" TIMESTAMP NOT NULL
+);
+CREATE INDEX "audit_redirection" ON "audit" ("namespace", "key");
+CREATE INDEX "audit_created_at" ON "audit" ("created_at");
+
+-- The audit log is append-only.
+CREATE TRIGGER "audit_no_update" BEFORE UPDATE ON "audit"
+BEGIN
+    SELECT RAISE(ABORT, 'the audit log is append-only');
+END;
+CREATE TRIGGER "audit_no_delete" BEFORE DELETE ON "audit"
+BEGIN
+    SELECT RAISE(ABORT, 'the audit log is append-only');
+END;
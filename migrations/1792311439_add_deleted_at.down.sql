@@ -0,0 +1,2 @@
+DROP INDEX "redirection_deleted_at";
+ALTER TABLE "redirection" DROP COLUMN "deleted_at";
@@ -0,0 +1,2 @@
+ALTER TABLE "redirection" ADD COLUMN "deleted_at" TIMESTAMP;
+CREATE INDEX "redirection_deleted_at" ON "redirection" ("deleted_at");
@@ -0,0 +1 @@
+ALTER TABLE "redirection" ADD COLUMN "version" INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,12 @@
+-- The status, header and body are NULL while the request is in progress.
+CREATE TABLE "idempotency_key" (
+    "subject" TEXT NOT NULL,
+    "key" TEXT NOT NULL,
+    "fingerprint" TEXT NOT NULL,
+    "status" INTEGER,
+    "header" TEXT,
+    "body" BLOB,
+    "created_at" TIMESTAMP NOT NULL,
+    PRIMARY KEY ("subject", "key")
+);
+CREATE INDEX "idempotency_key_created_at" ON "idempotency_key" ("created_at");
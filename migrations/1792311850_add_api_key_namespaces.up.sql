@@ -0,0 +1,3 @@
+-- The namespaces a key can use as JSON list, keys without only use the
+-- default namespace unless they are admin.
+ALTER TABLE "api_key" ADD COLUMN "namespaces" TEXT;
@@ -0,0 +1,12 @@
+UPDATE "redirection" SET "owner" = (SELECT "name" FROM "api_key" WHERE 'api_key:' || "id" = "redirection"."owner")
+WHERE EXISTS (SELECT 1 FROM "api_key" WHERE 'api_key:' || "id" = "redirection"."owner");
+
+DROP TRIGGER "audit_no_update";
+UPDATE "audit" SET "actor" = (SELECT "name" FROM "api_key" WHERE 'api_key:' || "id" = "audit"."actor")
+WHERE EXISTS (SELECT 1 FROM "api_key" WHERE 'api_key:' || "id" = "audit"."actor");
+CREATE TRIGGER "audit_no_update" BEFORE UPDATE ON "audit"
+BEGIN
+    SELECT RAISE(ABORT, 'the audit log is append-only');
+END;
+
+DELETE FROM "idempotency_key";
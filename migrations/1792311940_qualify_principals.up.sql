@@ -0,0 +1,40 @@
+-- Owners and actors are qualified with how the caller authenticated, e.g.
+-- "api_key:12" (the id of the key) or "jwt:<iss>:<sub>". Existing ones are the
+-- name of an API key, which is resolved to the key with that name that was
+-- active at the time. Names without such a key (e.g. JWT subjects) are kept,
+-- they don't match any caller anymore. Stored idempotency keys are dropped.
+UPDATE "redirection" SET "owner" = 'api_key:' || (
+    SELECT "id" FROM "api_key"
+    WHERE "name" = "redirection"."owner"
+    AND datetime("api_key"."created_at") <= datetime("redirection"."created_at")
+    AND ("revoked_at" IS NULL OR datetime("revoked_at") >= datetime("redirection"."created_at"))
+    ORDER BY "id" DESC LIMIT 1
+)
+WHERE "owner" IS NOT NULL AND EXISTS (
+    SELECT 1 FROM "api_key"
+    WHERE "name" = "redirection"."owner"
+    AND datetime("api_key"."created_at") <= datetime("redirection"."created_at")
+    AND ("revoked_at" IS NULL OR datetime("revoked_at") >= datetime("redirection"."created_at"))
+);
+
+-- The audit log is append-only, except for this rewrite.
+DROP TRIGGER "audit_no_update";
+UPDATE "audit" SET "actor" = 'api_key:' || (
+    SELECT "id" FROM "api_key"
+    WHERE "name" = "audit"."actor"
+    AND datetime("api_key"."created_at") <= datetime("audit"."created_at")
+    AND ("revoked_at" IS NULL OR datetime("revoked_at") >= datetime("audit"."created_at"))
+    ORDER BY "id" DESC LIMIT 1
+)
+WHERE "actor" IS NOT NULL AND EXISTS (
+    SELECT 1 FROM "api_key"
+    WHERE "name" = "audit"."actor"
+    AND datetime("api_key"."created_at") <= datetime("audit"."created_at")
+    AND ("revoked_at" IS NULL OR datetime("revoked_at") >= datetime("audit"."created_at"))
+);
+CREATE TRIGGER "audit_no_update" BEFORE UPDATE ON "audit"
+BEGIN
+    SELECT RAISE(ABORT, 'the audit log is append-only');
+END;
+
+DELETE FROM "idempotency_key";
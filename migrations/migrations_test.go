@@ -1,12 +1,17 @@
 package migrations
 
 import (
+	"database/sql"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
 	"testing"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/sqlite"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
 )
 
 // TestMigrations tests if the latest.lock file contains the name of the latest
@@ -58,3 +63,80 @@ func TestLatestLock(t *testing.T) {
 		t.Fatalf("latest.lock is wrong, %q != %q", latest, highest)
 	}
 }
+
+// TestQualifyPrincipals migrates a database with API keys, owned redirections
+// and audit history to qualified principals and back.
+func TestQualifyPrincipals(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:?_time_format=sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	source, err := iofs.New(migrations, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	database, err := sqlite.WithInstance(db, &sqlite.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := migrate.NewWithInstance("iofs", source, "sqlite", database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Migrate(1792311850); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first "ci" key was revoked and its name reused by the second.
+	for _, q := range []string{
+		`INSERT INTO api_key (id, name, prefix, role, hash, created_at, revoked_at) VALUES (1, 'ci', 'rk_1', 'editor', 'a', '2009-11-01 00:00:00', '2009-11-05 00:00:00')`,
+		`INSERT INTO api_key (id, name, prefix, role, hash, created_at) VALUES (2, 'ci', 'rk_2', 'editor', 'b', '2009-11-06 00:00:00')`,
+		`INSERT INTO redirection (namespace, key, url, owner, created_at, updated_at) VALUES ('default', 'old', 'https://example.com', 'ci', '2009-11-02 00:00:00', '2009-11-02 00:00:00')`,
+		`INSERT INTO redirection (namespace, key, url, owner, created_at, updated_at) VALUES ('default', 'new', 'https://example.com', 'ci', '2009-11-07 00:00:00', '2009-11-07 00:00:00')`,
+		`INSERT INTO redirection (namespace, key, url, owner, created_at, updated_at) VALUES ('default', 'jwt', 'https://example.com', 'alice', '2009-11-07 00:00:00', '2009-11-07 00:00:00')`,
+		`INSERT INTO audit (namespace, key, action, actor, created_at) VALUES ('default', 'old', 'create', 'ci', '2009-11-02 00:00:00')`,
+		`INSERT INTO audit (namespace, key, action, actor, created_at) VALUES ('default', 'new', 'create', 'ci', '2009-11-07 00:00:00')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check := func(query string, want ...string) {
+		t.Helper()
+		rows, err := db.Query(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		var got []string
+		for rows.Next() {
+			var v string
+			if err := rows.Scan(&v); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, v)
+		}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Fatalf("%s: got %v, want %v", query, got, want)
+		}
+	}
+
+	if err := m.Steps(1); err != nil {
+		t.Fatal(err)
+	}
+	check(`SELECT owner FROM redirection ORDER BY key`, "alice", "api_key:2", "api_key:1")
+	check(`SELECT actor FROM audit ORDER BY id`, "api_key:1", "api_key:2")
+	if _, err := db.Exec(`UPDATE audit SET actor = 'someone'`); err == nil {
+		t.Fatal("the audit log can be updated after the migration")
+	}
+
+	if err := m.Steps(-1); err != nil {
+		t.Fatal(err)
+	}
+	check(`SELECT owner FROM redirection ORDER BY key`, "alice", "ci", "ci")
+	check(`SELECT actor FROM audit ORDER BY id`, "ci", "ci")
+}